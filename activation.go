@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/pkg/errors"
+)
+
+// activatedSockets holds the listening sockets passed by systemd socket
+// activation, by their FileDescriptorName=. Secrets with a socket passed are
+// served on it rather than on one bound by the daemon, leaving systemd to
+// own the socket's lifecycle and start the daemon on demand.
+type activatedSockets struct {
+	sync.Mutex
+	listeners map[string]net.Listener
+}
+
+// receiveSockets takes the sockets passed by systemd, warning of any that no
+// secret will be served on.
+func (app *App) receiveSockets() error {
+	named, err := activation.ListenersWithNames()
+	if err != nil {
+		return errors.Wrap(err, "receiving sockets from systemd")
+	}
+
+	app.activated.listeners = map[string]net.Listener{}
+	for name, listeners := range named {
+		for _, ln := range listeners {
+			if ln == nil {
+				continue
+			}
+			if _, ok := app.activated.listeners[name]; ok {
+				logWarning("Ignoring additional socket named %s passed by systemd", name)
+				ln.Close()
+				continue
+			}
+			app.activated.listeners[name] = ln
+		}
+	}
+
+	names := map[string]bool{}
+	for _, secret := range app.config.Secrets {
+		names[secret.fdName()] = true
+	}
+	for name := range app.activated.listeners {
+		if !names[name] {
+			logWarning("No secret configured for socket %s passed by systemd", name)
+		}
+	}
+	return nil
+}
+
+// activatedListener returns the listener systemd passed for secret, if any.
+func (app *App) activatedListener(secret Secret) (net.Listener, bool) {
+	app.activated.Lock()
+	defer app.activated.Unlock()
+
+	ln, ok := app.activated.listeners[secret.fdName()]
+	return ln, ok
+}
+
+// fdName returns the FileDescriptorName= of the socket systemd passes for the secret.
+func (s Secret) fdName() string {
+	if s.FDName != "" {
+		return s.FDName
+	}
+	return s.SocketPath
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type AuthConfig struct {
+	Method string `yaml:"method"` // The auth method used to obtain a Vault token (approle, kubernetes, aws, gcp, azure, cert, jwt, ldap, userpass, kerberos, token_file)
+
+	AppRole    *AppRoleAuth    `yaml:"approle"`
+	Kubernetes *KubernetesAuth `yaml:"kubernetes"`
+	AWS        *AWSAuth        `yaml:"aws"`
+	GCP        *GCPAuth        `yaml:"gcp"`
+	Azure      *AzureAuth      `yaml:"azure"`
+	Cert       *CertAuth       `yaml:"cert"`
+	JWT        *JWTAuth        `yaml:"jwt"`
+	LDAP       *PasswordAuth   `yaml:"ldap"`
+	UserPass   *PasswordAuth   `yaml:"userpass"`
+	Kerberos   *KerberosAuth   `yaml:"kerberos"`
+	TokenFile  string          `yaml:"token_file"`       // A token sink file maintained by Vault Agent
+	TokenCred  string          `yaml:"token_credential"` // A systemd credential (in $CREDENTIALS_DIRECTORY) containing a token, for the token_file method
+
+	TokenType string `yaml:"token_type"` // Exchange the login token for a token of this type (batch or service)
+	Orphan    bool   `yaml:"orphan"`     // Exchange the login token for an orphan token, revoking the login token
+
+	RevokeOnShutdown bool `yaml:"revoke_on_shutdown"` // Revoke the token when the daemon stops
+
+	Namespace string `yaml:"namespace"` // The Vault Enterprise namespace to log in to and use by default (optional, defaults to the global namespace)
+}
+
+// AuthMethod is a way of logging in to Vault. Implementations register
+// themselves by name with registerAuthMethod, and the autoAuth lifecycle takes
+// care of renewing, re-authenticating and retrying for all of them alike.
+type AuthMethod interface {
+	// Login authenticates to Vault, returning the login response.
+	Login(ctx context.Context, client *api.Client) (*api.Secret, error)
+	// Renewable reports whether the daemon should renew tokens from this
+	// method itself, rather than leaving that to whoever issued them.
+	Renewable() bool
+	// Reauth returns a channel signalled when the method's credentials
+	// change and a fresh login should be made, or nil if they never do.
+	Reauth(ctx context.Context) (<-chan struct{}, error)
+}
+
+// tlsConfigurer is implemented by auth methods that authenticate at the TLS
+// layer, and so must configure the Vault client before it is created.
+type tlsConfigurer interface {
+	configureTLS(config *api.Config) error
+}
+
+type authMethodFactory func(config *AuthConfig) (AuthMethod, error)
+
+var authMethods = map[string]authMethodFactory{}
+
+// registerAuthMethod makes an auth method available under name for selection
+// with the auth configuration's method setting.
+func registerAuthMethod(name string, factory authMethodFactory) {
+	authMethods[name] = factory
+}
+
+func errMissingAuthConfig(method string) error {
+	return errors.Errorf("%s auth selected but no %s configuration given", method, method)
+}
+
+// newAuthMethod returns the Vault auth method selected by the auth configuration.
+func newAuthMethod(config *AuthConfig) (AuthMethod, error) {
+	factory, ok := authMethods[config.Method]
+	if !ok {
+		return nil, errors.Errorf("unsupported auth method %q", config.Method)
+	}
+
+	method, err := factory(config)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.TokenType {
+	case "", "batch", "service":
+	default:
+		return nil, errors.Errorf("unsupported token_type %q", config.TokenType)
+	}
+	if config.TokenType != "" || config.Orphan {
+		method = &derivedTokenMethod{
+			AuthMethod:    method,
+			tokenType:     config.TokenType,
+			orphan:        config.Orphan,
+			parentExpired: make(chan struct{}, 1),
+		}
+	}
+
+	return method, nil
+}
+
+// derivedTokenMethod logs in with another auth method and exchanges the
+// resulting token for a batch and/or orphan token. Most auth methods can't be
+// asked for either at login time. Batch tokens are not persisted by Vault, so
+// many daemons using them place far less load on its storage. A token that
+// isn't an orphan is revoked along with the login token it was created with,
+// so the login token is kept renewed, and a new login made once it can't be.
+type derivedTokenMethod struct {
+	AuthMethod
+	tokenType string
+	orphan    bool
+
+	stopParent    func()        // Stops keeping the login token of the current token renewed
+	parentExpired chan struct{} // Signalled when the login token is about to expire
+}
+
+func (d *derivedTokenMethod) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	login, err := d.AuthMethod.Login(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	creator, err := client.Clone()
+	if err != nil {
+		return nil, errors.Wrap(err, "cloning Vault client")
+	}
+	creator.SetToken(login.Auth.ClientToken)
+
+	request := &api.TokenCreateRequest{
+		Type: d.tokenType,
+	}
+	var secret *api.Secret
+	if d.orphan {
+		// Requires sudo on auth/token/create-orphan
+		secret, err = creator.Auth().Token().CreateOrphanWithContext(ctx, request)
+	} else {
+		secret, err = creator.Auth().Token().CreateWithContext(ctx, request)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "creating derived token")
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New("no auth information returned creating derived token")
+	}
+
+	// An orphan doesn't depend on the login token, which can go straight away
+	if d.orphan {
+		if err = creator.Auth().Token().RevokeSelfWithContext(ctx, ""); err != nil {
+			logError("Error revoking login token: %+v", err)
+		}
+	} else {
+		d.renewParent(creator, login)
+	}
+
+	return secret, nil
+}
+
+// renewParent keeps the login token the current token was created with
+// renewed, in place of that of any previous login, signalling parentExpired
+// once it can no longer be.
+func (d *derivedTokenMethod) renewParent(creator *api.Client, login *api.Secret) {
+	if d.stopParent != nil {
+		d.stopParent()
+	}
+
+	if login.Auth.Renewable {
+		watcher, err := creator.NewLifetimeWatcher(&api.LifetimeWatcherInput{Secret: login})
+		if err == nil {
+			stopped := make(chan struct{})
+			d.stopParent = func() {
+				close(stopped)
+				watcher.Stop()
+			}
+			go watcher.Start()
+			go func() {
+				for {
+					select {
+					case <-stopped:
+						return
+					case <-watcher.RenewCh():
+					case err := <-watcher.DoneCh():
+						select {
+						case <-stopped:
+							return
+						default:
+						}
+						logWarning("Login token can no longer be renewed, logging in again: %+v", err)
+						signalRefresh(d.parentExpired)
+						return
+					}
+				}
+			}()
+			return
+		}
+		logWarning("Unable to renew login token: %+v", err)
+	}
+
+	// Log in again well before the login token expires
+	ttl := time.Duration(login.Auth.LeaseDuration) * time.Second
+	if ttl <= 0 {
+		d.stopParent = nil
+		return
+	}
+	timer := time.AfterFunc(ttl*2/3, func() {
+		log.Print("Login token expiring, logging in again")
+		signalRefresh(d.parentExpired)
+	})
+	d.stopParent = func() { timer.Stop() }
+}
+
+// Reauth signals a fresh login when the login credentials change, or when a
+// token that isn't an orphan would be revoked with its login token.
+func (d *derivedTokenMethod) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	rotated, err := d.AuthMethod.Reauth(ctx)
+	if d.orphan {
+		return rotated, err
+	}
+
+	reauth := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-rotated:
+				signalRefresh(reauth)
+			case <-d.parentExpired:
+				signalRefresh(reauth)
+			}
+		}
+	}()
+	return reauth, err
+}
+
+func (d *derivedTokenMethod) configureTLS(config *api.Config) error {
+	if t, ok := d.AuthMethod.(tlsConfigurer); ok {
+		return t.configureTLS(config)
+	}
+	return nil
+}
+
+var errNoValue = errors.New("no value configured")
+
+// readValue returns a credential from whichever of an inline value, a file, an
+// environment variable, or a systemd credential is configured, in that order of
+// preference.
+func readValue(name, inline, file, env, credential string) (string, error) {
+	if inline != "" {
+		return inline, nil
+	}
+
+	if file == "" && credential != "" {
+		path, err := credentialPath(credential)
+		if err != nil {
+			return "", err
+		}
+		file = path
+	}
+
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", errors.Wrapf(err, "reading %s from file", name)
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	if env != "" {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return "", errors.Errorf("environment variable %s for %s is not set", env, name)
+		}
+		return strings.TrimSpace(value), nil
+	}
+
+	return "", errors.Wrap(errNoValue, name)
+}
+
+// credentialPath returns the path of a systemd credential passed to the
+// daemon with LoadCredential= or SetCredential=.
+func credentialPath(name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", errors.Errorf("credential %s requested but $CREDENTIALS_DIRECTORY is not set", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func mountOrDefault(mount, method string) string {
+	if mount == "" {
+		return method
+	}
+	return strings.Trim(mount, "/")
+}
+
+// loginWrite performs a login request against the given path beneath auth/.
+func loginWrite(ctx context.Context, client *api.Client, path string, data map[string]interface{}) (*api.Secret, error) {
+	secret, err := client.Logical().WriteWithContext(ctx, "auth/"+path, data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "logging in to auth/%s", path)
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.Errorf("no auth information returned from auth/%s", path)
+	}
+	return secret, nil
+}
+
+// doHTTP performs req and returns the response body, treating any non-2xx status as an error.
+func doHTTP(req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// isPermissionDenied reports whether err is Vault refusing the request, as
+// happens once the token has expired or been revoked.
+func isPermissionDenied(err error) bool {
+	var respErr *api.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type AppRoleAuth struct {
+	Mount        string `yaml:"mount"`                // The path the AppRole auth method is mounted at (default: approle)
+	RoleID       string `yaml:"role_id"`              // The role_id to log in with
+	RoleIDFile   string `yaml:"role_id_file"`         // A file containing the role_id
+	RoleIDEnv    string `yaml:"role_id_env"`          // An environment variable containing the role_id
+	RoleIDCred   string `yaml:"role_id_credential"`   // A systemd credential (in $CREDENTIALS_DIRECTORY) containing the role_id
+	SecretID     string `yaml:"secret_id"`            // The secret_id to log in with
+	SecretIDFile string `yaml:"secret_id_file"`       // A file containing the secret_id
+	SecretIDEnv  string `yaml:"secret_id_env"`        // An environment variable containing the secret_id
+	SecretIDCred string `yaml:"secret_id_credential"` // A systemd credential (in $CREDENTIALS_DIRECTORY) containing the secret_id
+
+	SecretIDWrapped bool `yaml:"secret_id_wrapped"` // The secret_id is a response-wrapping token to be unwrapped once at startup
+
+	unwrappedSecretID string
+}
+
+func init() {
+	registerAuthMethod("approle", func(config *AuthConfig) (AuthMethod, error) {
+		if config.AppRole == nil {
+			return nil, errMissingAuthConfig("approle")
+		}
+		return config.AppRole, nil
+	})
+}
+
+func (a *AppRoleAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	roleID, err := readValue("role_id", a.RoleID, a.RoleIDFile, a.RoleIDEnv, a.RoleIDCred)
+	if err != nil {
+		return nil, err
+	}
+
+	// The secret_id is optional, as a role may be bound by CIDR only
+	secretID, err := readValue("secret_id", a.SecretID, a.SecretIDFile, a.SecretIDEnv, a.SecretIDCred)
+	if err != nil && !errors.Is(err, errNoValue) {
+		return nil, err
+	}
+
+	if a.SecretIDWrapped {
+		// The wrapping token can only be used once, so later logins reuse
+		// the secret_id it contained.
+		if a.unwrappedSecretID == "" {
+			if a.unwrappedSecretID, err = unwrapSecretID(ctx, client, secretID); err != nil {
+				return nil, err
+			}
+		}
+		secretID = a.unwrappedSecretID
+	}
+
+	data := map[string]interface{}{
+		"role_id": roleID,
+	}
+	if secretID != "" {
+		data["secret_id"] = secretID
+	}
+
+	return loginWrite(ctx, client, mountOrDefault(a.Mount, "approle")+"/login", data)
+}
+
+func (a *AppRoleAuth) Renewable() bool {
+	return true
+}
+
+func (a *AppRoleAuth) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
+
+// unwrapSecretID unwraps a response-wrapped AppRole secret_id. The wrapping
+// token is checked first, so that one which has already been unwrapped, or
+// which did not come from an AppRole secret-id endpoint, is refused rather
+// than silently accepted: either indicates the secret_id may have been
+// intercepted.
+func unwrapSecretID(ctx context.Context, client *api.Client, wrappingToken string) (string, error) {
+	if wrappingToken == "" {
+		return "", errors.New("secret_id_wrapped is set but no wrapping token was given")
+	}
+
+	unwrapper, err := client.Clone()
+	if err != nil {
+		return "", errors.Wrap(err, "cloning Vault client")
+	}
+	unwrapper.SetToken(wrappingToken)
+
+	lookup, err := unwrapper.Logical().WriteWithContext(ctx, "sys/wrapping/lookup", map[string]interface{}{
+		"token": wrappingToken,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "secret_id wrapping token is invalid or has already been used")
+	}
+	if creationPath, _ := lookup.Data["creation_path"].(string); !strings.HasSuffix(creationPath, "/secret-id") {
+		return "", errors.Errorf("secret_id wrapping token was created by unexpected path %q", creationPath)
+	}
+
+	secret, err := unwrapper.Logical().UnwrapWithContext(ctx, "")
+	if err != nil {
+		return "", errors.Wrap(err, "unwrapping secret_id")
+	}
+	if secret == nil {
+		return "", errors.New("unwrapping secret_id returned no data")
+	}
+	secretID, _ := secret.Data["secret_id"].(string)
+	if secretID == "" {
+		return "", errors.New("wrapped response did not contain a secret_id")
+	}
+
+	log.Print("Unwrapped AppRole secret_id")
+	return secretID, nil
+}
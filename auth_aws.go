@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type AWSAuth struct {
+	Mount    string `yaml:"mount"`     // The path the AWS auth method is mounted at (default: aws)
+	Role     string `yaml:"role"`      // The Vault role to log in as (default: the role name of the IAM principal)
+	Region   string `yaml:"region"`    // The STS region to sign the login request for (default: us-east-1)
+	ServerID string `yaml:"server_id"` // The X-Vault-AWS-IAM-Server-ID header value required by the Vault role, if any
+}
+
+func init() {
+	registerAuthMethod("aws", func(config *AuthConfig) (AuthMethod, error) {
+		if config.AWS == nil {
+			return nil, errMissingAuthConfig("aws")
+		}
+		return config.AWS, nil
+	})
+}
+
+func (a *AWSAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	region := a.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	// Credentials are resolved by the SDK's default chain, which covers the
+	// EC2 instance profile and the ECS task role.
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, errors.Wrap(err, "creating AWS session")
+	}
+
+	// Vault verifies our identity by replaying a signed sts:GetCallerIdentity request
+	req, _ := sts.New(sess).GetCallerIdentityRequest(nil)
+	if a.ServerID != "" {
+		req.HTTPRequest.Header.Add("X-Vault-AWS-IAM-Server-ID", a.ServerID)
+	}
+	if err = req.Sign(); err != nil {
+		return nil, errors.Wrap(err, "signing sts:GetCallerIdentity request")
+	}
+
+	headers, err := json.Marshal(req.HTTPRequest.Header)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding request headers")
+	}
+	body, err := ioutil.ReadAll(req.HTTPRequest.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading request body")
+	}
+
+	data := map[string]interface{}{
+		"iam_http_request_method": req.HTTPRequest.Method,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(req.HTTPRequest.URL.String())),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headers),
+		"iam_request_body":        base64.StdEncoding.EncodeToString(body),
+	}
+	if a.Role != "" {
+		data["role"] = a.Role
+	}
+
+	return loginWrite(ctx, client, mountOrDefault(a.Mount, "aws")+"/login", data)
+}
+
+func (a *AWSAuth) Renewable() bool {
+	return true
+}
+
+func (a *AWSAuth) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type AzureAuth struct {
+	Mount          string `yaml:"mount"`           // The path the Azure auth method is mounted at (default: azure)
+	Role           string `yaml:"role"`            // The Vault role to log in as
+	Resource       string `yaml:"resource"`        // The resource the managed identity token is requested for (default: https://management.azure.com/)
+	SubscriptionID string `yaml:"subscription_id"` // Overrides the subscription reported by the instance metadata service
+	ResourceGroup  string `yaml:"resource_group"`  // Overrides the resource group reported by the instance metadata service
+}
+
+func init() {
+	registerAuthMethod("azure", func(config *AuthConfig) (AuthMethod, error) {
+		if config.Azure == nil {
+			return nil, errMissingAuthConfig("azure")
+		}
+		return config.Azure, nil
+	})
+}
+
+func (a *AzureAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	if a.Role == "" {
+		return nil, errors.New("azure auth requires a role")
+	}
+
+	resource := a.Resource
+	if resource == "" {
+		resource = "https://management.azure.com/"
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := azureMetadataGet(ctx, "identity/oauth2/token", url.Values{
+		"api-version": {"2018-02-01"},
+		"resource":    {resource},
+	}, &token)
+	if err != nil {
+		return nil, err
+	}
+
+	var instance struct {
+		Compute struct {
+			Name              string `json:"name"`
+			ResourceGroupName string `json:"resourceGroupName"`
+			SubscriptionID    string `json:"subscriptionId"`
+			VMScaleSetName    string `json:"vmScaleSetName"`
+		} `json:"compute"`
+	}
+	err = azureMetadataGet(ctx, "instance", url.Values{
+		"api-version": {"2017-08-01"},
+	}, &instance)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"role":                a.Role,
+		"jwt":                 token.AccessToken,
+		"subscription_id":     instance.Compute.SubscriptionID,
+		"resource_group_name": instance.Compute.ResourceGroupName,
+	}
+	if instance.Compute.VMScaleSetName != "" {
+		data["vmss_name"] = instance.Compute.VMScaleSetName
+	} else {
+		data["vm_name"] = instance.Compute.Name
+	}
+	if a.SubscriptionID != "" {
+		data["subscription_id"] = a.SubscriptionID
+	}
+	if a.ResourceGroup != "" {
+		data["resource_group_name"] = a.ResourceGroup
+	}
+
+	return loginWrite(ctx, client, mountOrDefault(a.Mount, "azure")+"/login", data)
+}
+
+func (a *AzureAuth) Renewable() bool {
+	return true
+}
+
+func (a *AzureAuth) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
+
+const azureMetadataURL = "http://169.254.169.254/metadata/"
+
+func azureMetadataGet(ctx context.Context, path string, query url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, azureMetadataURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return errors.Wrap(err, "building Azure metadata request")
+	}
+	req.Header.Set("Metadata", "true")
+
+	body, err := doHTTP(req)
+	if err != nil {
+		return errors.Wrapf(err, "querying Azure metadata %s", path)
+	}
+	if err = json.Unmarshal(body, out); err != nil {
+		return errors.Wrapf(err, "decoding Azure metadata %s", path)
+	}
+	return nil
+}
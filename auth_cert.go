@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type CertAuth struct {
+	Mount      string `yaml:"mount"`       // The path the TLS certificate auth method is mounted at (default: cert)
+	Role       string `yaml:"role"`        // The certificate role to log in as (default: any matching role)
+	ClientCert string `yaml:"client_cert"` // PEM encoded client certificate presented to Vault
+	ClientKey  string `yaml:"client_key"`  // PEM encoded private key for the client certificate
+}
+
+func init() {
+	registerAuthMethod("cert", func(config *AuthConfig) (AuthMethod, error) {
+		if config.Cert == nil {
+			return nil, errMissingAuthConfig("cert")
+		}
+		return config.Cert, nil
+	})
+}
+
+// configureTLS sets up the Vault client to present the configured client
+// certificate, which is what the cert auth method authenticates.
+func (c *CertAuth) configureTLS(config *api.Config) error {
+	if c.ClientCert == "" || c.ClientKey == "" {
+		return errors.New("cert auth requires client_cert and client_key")
+	}
+	return config.ConfigureTLS(&api.TLSConfig{
+		ClientCert: c.ClientCert,
+		ClientKey:  c.ClientKey,
+	})
+}
+
+func (c *CertAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	data := map[string]interface{}{}
+	if c.Role != "" {
+		data["name"] = c.Role
+	}
+
+	return loginWrite(ctx, client, mountOrDefault(c.Mount, "cert")+"/login", data)
+}
+
+func (c *CertAuth) Renewable() bool {
+	return true
+}
+
+func (c *CertAuth) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
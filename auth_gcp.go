@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type GCPAuth struct {
+	Mount          string `yaml:"mount"`           // The path the GCP auth method is mounted at (default: gcp)
+	Role           string `yaml:"role"`            // The Vault role to log in as
+	ServiceAccount string `yaml:"service_account"` // Log in with an iam-type role as this service account instead of using the gce instance identity
+}
+
+func init() {
+	registerAuthMethod("gcp", func(config *AuthConfig) (AuthMethod, error) {
+		if config.GCP == nil {
+			return nil, errMissingAuthConfig("gcp")
+		}
+		return config.GCP, nil
+	})
+}
+
+func (g *GCPAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	if g.Role == "" {
+		return nil, errors.New("gcp auth requires a role")
+	}
+
+	var (
+		jwt string
+		err error
+	)
+	if g.ServiceAccount == "" {
+		jwt, err = gceMetadataGet(ctx, "instance/service-accounts/default/identity", url.Values{
+			"audience": {"http://vault/" + g.Role},
+			"format":   {"full"},
+		})
+	} else {
+		jwt, err = gcpSignJWT(ctx, g.Role, g.ServiceAccount)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"role": g.Role,
+		"jwt":  jwt,
+	}
+
+	return loginWrite(ctx, client, mountOrDefault(g.Mount, "gcp")+"/login", data)
+}
+
+func (g *GCPAuth) Renewable() bool {
+	return true
+}
+
+func (g *GCPAuth) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
+
+const gceMetadataURL = "http://metadata.google.internal/computeMetadata/v1/"
+
+func gceMetadataGet(ctx context.Context, path string, query url.Values) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gceMetadataURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "building GCE metadata request")
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	body, err := doHTTP(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "querying GCE metadata %s", path)
+	}
+	return string(body), nil
+}
+
+// gcpSignJWT has the IAM Credentials API sign a Vault login JWT on behalf of
+// serviceAccount, authenticating as the instance's default service account.
+func gcpSignJWT(ctx context.Context, role, serviceAccount string) (string, error) {
+	tokenJSON, err := gceMetadataGet(ctx, "instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.Unmarshal([]byte(tokenJSON), &token); err != nil {
+		return "", errors.Wrap(err, "decoding GCE access token")
+	}
+
+	// Vault rejects iam login JWTs valid for more than 15 minutes
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": "vault/" + role,
+		"sub": serviceAccount,
+		"exp": time.Now().Add(10 * time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "encoding JWT claims")
+	}
+	payload, err := json.Marshal(map[string]string{"payload": string(claims)})
+	if err != nil {
+		return "", errors.Wrap(err, "encoding signJwt request")
+	}
+
+	signURL := "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/" + url.PathEscape(serviceAccount) + ":signJwt"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, signURL, bytes.NewReader(payload))
+	if err != nil {
+		return "", errors.Wrap(err, "building signJwt request")
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	body, err := doHTTP(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "signing JWT for %s", serviceAccount)
+	}
+	var signed struct {
+		SignedJWT string `json:"signedJwt"`
+	}
+	if err = json.Unmarshal(body, &signed); err != nil {
+		return "", errors.Wrap(err, "decoding signJwt response")
+	}
+	return signed.SignedJWT, nil
+}
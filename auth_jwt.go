@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type JWTAuth struct {
+	Mount string `yaml:"mount"` // The path the JWT/OIDC auth method is mounted at (default: jwt)
+	Role  string `yaml:"role"`  // The Vault role to log in as
+	Path  string `yaml:"path"`  // A file containing the JWT, e.g. a workload identity token
+}
+
+func init() {
+	registerAuthMethod("jwt", func(config *AuthConfig) (AuthMethod, error) {
+		if config.JWT == nil {
+			return nil, errMissingAuthConfig("jwt")
+		}
+		return config.JWT, nil
+	})
+}
+
+func (j *JWTAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	if j.Role == "" || j.Path == "" {
+		return nil, errors.New("jwt auth requires a role and path")
+	}
+
+	jwt, err := readValue("jwt", "", j.Path, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"role": j.Role,
+		"jwt":  jwt,
+	}
+
+	return loginWrite(ctx, client, mountOrDefault(j.Mount, "jwt")+"/login", data)
+}
+
+func (j *JWTAuth) Renewable() bool {
+	return true
+}
+
+// Reauth watches the JWT file, as a token that has been rotated by its issuer
+// is worth logging in again with before the old one expires.
+func (j *JWTAuth) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	return watchFile(ctx, j.Path)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/hashicorp/vault/api"
+	krb5client "github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/pkg/errors"
+)
+
+type KerberosAuth struct {
+	Mount            string `yaml:"mount"`                    // The path the Kerberos auth method is mounted at (default: kerberos)
+	Username         string `yaml:"username"`                 // The principal to authenticate as, e.g. the host account
+	Realm            string `yaml:"realm"`                    // The Kerberos realm of the principal
+	KeytabPath       string `yaml:"keytab_path"`              // The keytab holding the principal's keys (default: /etc/krb5.keytab)
+	Krb5ConfPath     string `yaml:"krb5conf_path"`            // The Kerberos configuration file (default: /etc/krb5.conf)
+	ServicePrincipal string `yaml:"service_principal"`        // The SPN of the Vault server, e.g. HTTP/vault.example.com
+	DisableFAST      bool   `yaml:"disable_fast_negotiation"` // Disable PA-FX-FAST, which Active Directory does not support
+}
+
+func init() {
+	registerAuthMethod("kerberos", func(config *AuthConfig) (AuthMethod, error) {
+		if config.Kerberos == nil {
+			return nil, errMissingAuthConfig("kerberos")
+		}
+		return config.Kerberos, nil
+	})
+}
+
+func (k *KerberosAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	if k.Username == "" || k.Realm == "" || k.ServicePrincipal == "" {
+		return nil, errors.New("kerberos auth requires a username, realm and service_principal")
+	}
+
+	keytabPath := k.KeytabPath
+	if keytabPath == "" {
+		keytabPath = "/etc/krb5.keytab"
+	}
+	krb5ConfPath := k.Krb5ConfPath
+	if krb5ConfPath == "" {
+		krb5ConfPath = "/etc/krb5.conf"
+	}
+
+	kt, err := keytab.Load(keytabPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading keytab")
+	}
+	krb5Conf, err := krb5config.Load(krb5ConfPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading Kerberos configuration")
+	}
+
+	kc := krb5client.NewWithKeytab(k.Username, k.Realm, kt, krb5Conf, krb5client.DisablePAFXFAST(k.DisableFAST))
+	defer kc.Destroy()
+	if err = kc.Login(); err != nil {
+		return nil, errors.Wrap(err, "obtaining Kerberos ticket")
+	}
+
+	negotiator := spnego.SPNEGOClient(kc, k.ServicePrincipal)
+	if err = negotiator.AcquireCred(); err != nil {
+		return nil, errors.Wrap(err, "acquiring Kerberos credentials")
+	}
+	token, err := negotiator.InitSecContext()
+	if err != nil {
+		return nil, errors.Wrap(err, "initialising SPNEGO context")
+	}
+	negotiation, err := token.Marshal()
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding SPNEGO token")
+	}
+
+	// The Kerberos auth method takes its credentials from the request headers
+	path := "auth/" + mountOrDefault(k.Mount, "kerberos") + "/login"
+	req := client.NewRequest(http.MethodPost, "/v1/"+path)
+	req.Headers.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(negotiation))
+
+	resp, err := client.RawRequestWithContext(ctx, req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "logging in to %s", path)
+	}
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing response from %s", path)
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.Errorf("no auth information returned from %s", path)
+	}
+	return secret, nil
+}
+
+func (k *KerberosAuth) Renewable() bool {
+	return true
+}
+
+func (k *KerberosAuth) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type KubernetesAuth struct {
+	Mount     string `yaml:"mount"`      // The path the Kubernetes auth method is mounted at (default: kubernetes)
+	Role      string `yaml:"role"`       // The Vault role to log in as
+	TokenPath string `yaml:"token_path"` // The projected service account token (default: the in-pod token path)
+}
+
+const defaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+func init() {
+	registerAuthMethod("kubernetes", func(config *AuthConfig) (AuthMethod, error) {
+		if config.Kubernetes == nil {
+			return nil, errMissingAuthConfig("kubernetes")
+		}
+		return config.Kubernetes, nil
+	})
+}
+
+func (k *KubernetesAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	if k.Role == "" {
+		return nil, errors.New("kubernetes auth requires a role")
+	}
+
+	tokenPath := k.TokenPath
+	if tokenPath == "" {
+		tokenPath = defaultKubernetesTokenPath
+	}
+
+	// Projected tokens are rotated by the kubelet, so always read the current one
+	jwt, err := readValue("service account token", "", tokenPath, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"role": k.Role,
+		"jwt":  jwt,
+	}
+
+	return loginWrite(ctx, client, mountOrDefault(k.Mount, "kubernetes")+"/login", data)
+}
+
+func (k *KubernetesAuth) Renewable() bool {
+	return true
+}
+
+func (k *KubernetesAuth) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// PasswordAuth configures the username and password based auth methods.
+type PasswordAuth struct {
+	Mount        string `yaml:"mount"`               // The path the auth method is mounted at (default: ldap or userpass)
+	Username     string `yaml:"username"`            // The username to log in as
+	PasswordFile string `yaml:"password_file"`       // A file containing the password, read only while logging in
+	PasswordCred string `yaml:"password_credential"` // A systemd credential (in $CREDENTIALS_DIRECTORY) containing the password
+}
+
+// passwordMethod logs in with a PasswordAuth against a particular auth method.
+type passwordMethod struct {
+	*PasswordAuth
+	method string
+}
+
+func init() {
+	registerAuthMethod("ldap", func(config *AuthConfig) (AuthMethod, error) {
+		if config.LDAP == nil {
+			return nil, errMissingAuthConfig("ldap")
+		}
+		return &passwordMethod{config.LDAP, "ldap"}, nil
+	})
+	registerAuthMethod("userpass", func(config *AuthConfig) (AuthMethod, error) {
+		if config.UserPass == nil {
+			return nil, errMissingAuthConfig("userpass")
+		}
+		return &passwordMethod{config.UserPass, "userpass"}, nil
+	})
+}
+
+func (p *passwordMethod) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	if p.Username == "" {
+		return nil, errors.Errorf("%s auth requires a username", p.method)
+	}
+
+	// The password is read afresh for each login rather than kept around
+	password, err := readValue("password", "", p.PasswordFile, "", p.PasswordCred)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"password": password,
+	}
+
+	return loginWrite(ctx, client, mountOrDefault(p.Mount, p.method)+"/login/"+url.PathEscape(p.Username), data)
+}
+
+func (p *passwordMethod) Renewable() bool {
+	return true
+}
+
+func (p *passwordMethod) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
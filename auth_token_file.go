@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// tokenFileMethod uses the token written to a Vault Agent sink file.
+type tokenFileMethod struct {
+	path string
+}
+
+func init() {
+	registerAuthMethod("token_file", func(config *AuthConfig) (AuthMethod, error) {
+		if config.TokenCred != "" {
+			path, err := credentialPath(config.TokenCred)
+			if err != nil {
+				return nil, err
+			}
+			return &tokenFileMethod{path}, nil
+		}
+		if config.TokenFile == "" {
+			return nil, errors.New("token_file auth selected but no token_file or token_credential given")
+		}
+		return &tokenFileMethod{config.TokenFile}, nil
+	})
+}
+
+func (t *tokenFileMethod) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	token, err := readValue("token", "", t.path, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the token is usable before handing it over
+	lookup, err := client.Clone()
+	if err != nil {
+		return nil, errors.Wrap(err, "cloning Vault client")
+	}
+	lookup.SetToken(token)
+	self, err := lookup.Auth().Token().LookupSelfWithContext(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up token from %s", t.path)
+	}
+	accessor, _ := self.TokenAccessor()
+
+	// Vault Agent renews and replaces the token itself, so the token is
+	// reported as having no lease for us to manage.
+	return &api.Secret{
+		Auth: &api.SecretAuth{
+			ClientToken: token,
+			Accessor:    accessor,
+		},
+	}, nil
+}
+
+// Renewable is false as Vault Agent renews the token itself.
+func (t *tokenFileMethod) Renewable() bool {
+	return false
+}
+
+// Reauth watches the sink file for Vault Agent replacing the token.
+func (t *tokenFileMethod) Reauth(ctx context.Context) (<-chan struct{}, error) {
+	return watchFile(ctx, t.path)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/hashicorp/vault/api"
+)
+
+// autoAuth manages the lifecycle of the daemon's Vault login: the initial
+// login, keeping the token renewed for as long as Vault allows, and logging in
+// again once it can no longer be renewed, when the login credentials change,
+// or when Vault rejects the token. The daemon thereby keeps serving secrets
+// beyond the lifetime of a single login, and recovers from revoked tokens and
+// Vault restarts.
+type autoAuth struct {
+	client *api.Client
+	method AuthMethod
+	secret *api.Secret   // The most recent login response
+	reauth chan struct{} // Signals that Vault rejected the current token
+}
+
+func newAutoAuth(client *api.Client, method AuthMethod) *autoAuth {
+	return &autoAuth{
+		client: client,
+		method: method,
+		reauth: make(chan struct{}, 1),
+	}
+}
+
+// login performs the initial login, setting the client's token.
+func (a *autoAuth) login(ctx context.Context) error {
+	secret, err := a.client.Auth().Login(ctx, a.method)
+	if err != nil {
+		return err
+	}
+	a.secret = secret
+	return nil
+}
+
+// requestReauth asks for a new login, e.g. because Vault refused the token.
+func (a *autoAuth) requestReauth() {
+	select {
+	case a.reauth <- struct{}{}:
+	default:
+	}
+}
+
+// run maintains the login until ctx is cancelled.
+func (a *autoAuth) run(ctx context.Context) {
+	rotated, err := a.method.Reauth(ctx)
+	if err != nil {
+		logWarning("Not watching auth credentials for changes: %+v", err)
+	}
+
+	for {
+		if !a.awaitRelogin(ctx, rotated) {
+			return
+		}
+
+		retry := backoff.NewExponentialBackOff()
+		retry.MaxInterval = maxReloginInterval
+		retry.MaxElapsedTime = 0
+		for {
+			s, err := a.client.Auth().Login(ctx, a.method)
+			if err == nil {
+				log.Printf("Logged in to Vault again, token valid for %ds", s.Auth.LeaseDuration)
+				a.secret = s
+				break
+			}
+
+			wait := retry.NextBackOff()
+			logError("Error logging in to Vault again, retrying in %s: %+v", wait.Round(time.Second), err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// awaitRelogin renews the current token until a new login is required,
+// returning false if that will never happen.
+func (a *autoAuth) awaitRelogin(ctx context.Context, rotated <-chan struct{}) bool {
+	var (
+		renewed  <-chan *api.RenewOutput
+		done     <-chan error
+		expiring <-chan time.Time
+	)
+
+	if a.method.Renewable() && a.secret.Auth.Renewable {
+		watcher, err := a.client.NewLifetimeWatcher(&api.LifetimeWatcherInput{Secret: a.secret})
+		if err != nil {
+			logWarning("Unable to renew Vault token: %+v", err)
+		} else {
+			go watcher.Start()
+			defer watcher.Stop()
+			renewed, done = watcher.RenewCh(), watcher.DoneCh()
+		}
+	}
+
+	if done == nil {
+		// Without renewal, log in again well before the token expires
+		if ttl := time.Duration(a.secret.Auth.LeaseDuration) * time.Second; ttl > 0 {
+			expiring = time.After(ttl * 2 / 3)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case r := <-renewed:
+			log.Printf("Renewed Vault token, valid for %ds", r.Secret.Auth.LeaseDuration)
+		case err := <-done:
+			if err != nil {
+				logError("Error renewing Vault token, logging in again: %+v", err)
+			} else {
+				log.Print("Vault token reached its maximum TTL, logging in again")
+			}
+			return true
+		case <-expiring:
+			return true
+		case <-rotated:
+			log.Print("Auth credentials changed, logging in to Vault again")
+			return true
+		case <-a.reauth:
+			log.Print("Vault rejected the token, logging in again")
+			return true
+		}
+	}
+}
+
+const maxReloginInterval = 5 * time.Minute
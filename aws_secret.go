@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type AWSSecret struct {
+	Role     string `yaml:"role"`     // The AWS secrets engine role to generate credentials for
+	Endpoint string `yaml:"endpoint"` // creds (the default) or sts
+	TTL      string `yaml:"ttl"`      // The requested lifetime of STS credentials (optional)
+}
+
+// awsQuery returns the request parameters for an aws secret.
+func awsQuery(secret Secret) url.Values {
+	if secret.AWS.TTL == "" {
+		return nil
+	}
+	return url.Values{"ttl": {secret.AWS.TTL}}
+}
+
+// awsCredentials renders AWS credentials as the environment variables read
+// by the AWS SDKs and CLI (the default), or as a shared credentials file.
+func awsCredentials(data map[string]interface{}) (*secretValue, error) {
+	accessKey, _ := data["access_key"].(string)
+	secretKey, _ := data["secret_key"].(string)
+	sessionToken, _ := data["security_token"].(string)
+	if token, ok := data["session_token"].(string); ok && token != "" {
+		sessionToken = token
+	}
+
+	var env, file strings.Builder
+	env.WriteString(fmt.Sprintf("AWS_ACCESS_KEY_ID=%s\nAWS_SECRET_ACCESS_KEY=%s\n", accessKey, secretKey))
+	file.WriteString(fmt.Sprintf("[default]\naws_access_key_id = %s\naws_secret_access_key = %s\n", accessKey, secretKey))
+	if sessionToken != "" {
+		env.WriteString(fmt.Sprintf("AWS_SESSION_TOKEN=%s\n", sessionToken))
+		file.WriteString(fmt.Sprintf("aws_session_token = %s\n", sessionToken))
+	}
+
+	return &secretValue{
+		Data:    data,
+		Default: []byte(env.String()),
+		Formats: map[string][]byte{
+			"env":         []byte(env.String()),
+			"credentials": []byte(file.String()),
+		},
+	}, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type AzureSecret struct {
+	Role     string `yaml:"role"`      // The Azure role to generate a service principal for
+	TenantID string `yaml:"tenant_id"` // Included as AZURE_TENANT_ID in env output (optional)
+}
+
+// azureCredentials returns a function rendering service principal credentials
+// as the environment variables read by the Azure SDKs and CLI.
+func azureCredentials(secret Secret) func(map[string]interface{}) (*secretValue, error) {
+	return func(data map[string]interface{}) (*secretValue, error) {
+		clientID, _ := data["client_id"].(string)
+		clientSecret, _ := data["client_secret"].(string)
+
+		var env strings.Builder
+		env.WriteString(fmt.Sprintf("AZURE_CLIENT_ID=%s\nAZURE_CLIENT_SECRET=%s\n", clientID, clientSecret))
+		if secret.Azure.TenantID != "" {
+			env.WriteString(fmt.Sprintf("AZURE_TENANT_ID=%s\n", secret.Azure.TenantID))
+		}
+
+		return &secretValue{
+			Data:    data,
+			Default: []byte(env.String()),
+			Formats: map[string][]byte{"env": []byte(env.String())},
+		}, nil
+	}
+}
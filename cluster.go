@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Cluster is a Vault server secrets can be fetched from, along with how to
+// reach and log in to it.
+type Cluster struct {
+	Address       string `yaml:"address"`         // Address of the Vault server
+	CACert        string `yaml:"ca_cert"`         // PEM encoded CA certificate to verify the server with (optional)
+	CAPath        string `yaml:"ca_path"`         // Directory of PEM encoded CA certificates to verify the server with (optional)
+	TLSServerName string `yaml:"tls_server_name"` // The name to verify the server's certificate against (optional)
+	TLSSkipVerify bool   `yaml:"tls_skip_verify"` // Don't verify the server's certificate. Insecure.
+
+	Mount       string      `yaml:"mount"`       // The KV mount secrets are read from (optional, defaults to vault_mount)
+	OpenBao     bool        `yaml:"openbao"`     // The server is OpenBao: BAO_* environment variables are read as well as VAULT_*, its health checked as OpenBao reports it, and consistency, needing Vault Enterprise, refused
+	Namespace   string      `yaml:"namespace"`   // The Vault Enterprise namespace to use (optional)
+	Consistency string      `yaml:"consistency"` // Avoiding stale reads from Raft standbys, as for the default server (optional)
+	Auth        *AuthConfig `yaml:"auth"`        // How to log in (optional, otherwise VAULT_TOKEN is used)
+}
+
+// defaultCluster returns the Vault server configured at the top level, or as
+// the default profile under vaults.
+func (c *Config) defaultCluster() *Cluster {
+	if profile := c.Vaults[defaultVault]; profile != nil {
+		return profile
+	}
+	cluster := &Cluster{
+		OpenBao:     c.OpenBao,
+		Namespace:   c.Namespace,
+		Consistency: c.Consistency,
+		Auth:        c.Auth,
+	}
+	if c.VaultServer != nil {
+		cluster.Address = *c.VaultServer
+	}
+	return cluster
+}
+
+// product returns the name of the server software, for log messages.
+func (c *Cluster) product() string {
+	if c.OpenBao {
+		return "OpenBao"
+	}
+	return "Vault"
+}
+
+// configureTLS sets up the Vault client to verify the cluster's certificate.
+func (c *Cluster) configureTLS(config *api.Config) error {
+	if c.CACert == "" && c.CAPath == "" && c.TLSServerName == "" && !c.TLSSkipVerify {
+		return nil
+	}
+	err := config.ConfigureTLS(&api.TLSConfig{
+		CACert:        c.CACert,
+		CAPath:        c.CAPath,
+		TLSServerName: c.TLSServerName,
+		Insecure:      c.TLSSkipVerify,
+	})
+	return errors.Wrap(err, "error configuring Vault server TLS")
+}
+
+// defaultVault names the profile under vaults used by secrets naming none.
+const defaultVault = "default"
+
+// vaultProfiles sets up the Vault servers configured under vaults: the
+// default profile in place of the top-level settings, and the others as
+// clusters.
+func (c *Config) vaultProfiles() error {
+	for name, profile := range c.Vaults {
+		if profile == nil || profile.Address == "" {
+			return errors.Errorf("vault %s has no address", name)
+		}
+		if name == defaultVault {
+			if c.VaultServer != nil || c.VaultMount != "" || c.Auth != nil || c.Namespace != "" || c.Consistency != "" || c.OpenBao {
+				return errors.Errorf("vault %s can't be configured along with vault_server, vault_mount, auth, namespace, consistency or openbao", name)
+			}
+			c.VaultMount, c.Auth = profile.Mount, profile.Auth
+			continue
+		}
+		if _, ok := c.Clusters[name]; ok {
+			return errors.Errorf("vault %s is also configured under clusters", name)
+		}
+		if c.Clusters == nil {
+			c.Clusters = map[string]*Cluster{}
+		}
+		c.Clusters[name] = profile
+	}
+
+	c.eachSecret(c.resolveVault)
+	return nil
+}
+
+// clusterName returns the name of the cluster a secret naming cluster is
+// fetched from: none for the default profile, which is the default server.
+func (c *Config) clusterName(cluster string) string {
+	if cluster == defaultVault && c.Vaults[defaultVault] != nil {
+		return ""
+	}
+	return cluster
+}
+
+// resolveVault points secret at the named cluster for the Vault server it
+// sets, if any. Secrets with the same server settings share a cluster. A
+// vault is never set along with a cluster or identity, as validation and
+// withDefaults see to.
+func (c *Config) resolveVault(secret *Secret) {
+	secret.Cluster = c.clusterName(secret.Cluster)
+	if secret.Vault == nil {
+		return
+	}
+
+	name := "vault#" + secret.SocketPath
+	for other, cluster := range c.Clusters {
+		if strings.HasPrefix(other, "vault#") && reflect.DeepEqual(cluster, secret.Vault) {
+			name = other
+		}
+	}
+	if c.Clusters == nil {
+		c.Clusters = map[string]*Cluster{}
+	}
+	c.Clusters[name] = secret.Vault
+	secret.Cluster, secret.Vault = name, nil
+}
+
+// clusterClient is the client for a named cluster, and its login if one is configured.
+type clusterClient struct {
+	client *api.Client
+	auth   *autoAuth
+}
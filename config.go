@@ -1,38 +1,459 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/go-yaml/yaml"
 	"github.com/pkg/errors"
 )
 
 type Config struct {
-	VaultServer *string `yaml:"vault_server"` // Address of the Vault server
-	SocketRoot  string  `yaml:"socket_root"`  // The base path in which Unix sockets will be created
-	VaultMount  string  `yaml:"vault_mount"`  // The Secret Mount within vault to look for secrets
+	VaultServer   *string `yaml:"vault_server"`    // Address of the Vault server
+	OpenBao       bool    `yaml:"openbao"`         // The server is OpenBao: BAO_* environment variables are read as well as VAULT_*, its health checked as OpenBao reports it, and consistency, needing Vault Enterprise, refused
+	SocketRoot    string  `yaml:"socket_root"`     // The base path in which Unix sockets will be created (default: /run/vault-credentials/, or under $XDG_RUNTIME_DIR for systemd --user)
+	SocketMode    string  `yaml:"socket_mode"`     // The octal permissions of sockets, unless overridden by their mode (default: 0700)
+	SocketDirMode string  `yaml:"socket_dir_mode"` // The octal permissions of directories created for sockets beneath socket_root (default: 0755)
+	VaultMount    string  `yaml:"vault_mount"`     // The Secret Mount within vault to look for secrets
+	KVVersion     int     `yaml:"kv_version"`      // The KV engine version of the mount (1 or 2, detected if unset)
+	Namespace     string  `yaml:"namespace"`       // The Vault Enterprise namespace to use (optional)
+	Consistency   string  `yaml:"consistency"`     // Avoiding stale reads from Raft standbys: read_your_writes, forward_inconsistent, or forward_always (optional)
 
-	Secrets []Secret `yaml:"secrets"`
+	Auth       *AuthConfig            `yaml:"auth"`       // How to log in to Vault (optional, otherwise VAULT_TOKEN is used)
+	Identities map[string]*AuthConfig `yaml:"identities"` // Additional named logins which secrets can be fetched with
+	Clusters   map[string]*Cluster    `yaml:"clusters"`   // Additional named Vault servers which secrets can be fetched from
+	Vaults     map[string]*Cluster    `yaml:"vaults"`     // Named Vault servers, selected per secret with cluster:, the one named default in place of vault_server, vault_mount and auth
+	Preflight  string                 `yaml:"preflight"`  // Whether missing read capabilities on secrets are logged at startup (warn, the default), fatal (fail), or not checked (off)
+
+	TokenTTLWarning time.Duration `yaml:"token_ttl_warning"` // Log warnings when the token TTL drops below this (default: 10m)
+	MetricsAddress  string        `yaml:"metrics_address"`   // Address to serve metrics on at /debug/vars, without authentication, so best kept to localhost (optional)
+	LogTarget       string        `yaml:"log_target"`        // Where logs go: journal, with structured fields, stderr, or auto to use the journal when output goes to it anyway (default: auto)
+	DBus            string        `yaml:"dbus"`              // Serve a management interface on the system or session D-Bus (optional)
+	ControlSocket   string        `yaml:"control_socket"`    // The path of a Unix socket accepting administrative commands, as sent by the ctl command (optional)
+	ControlUIDs     []uint32      `yaml:"control_uids"`      // The users allowed to send commands, on the control socket or over D-Bus (default: root and the daemon's user)
+
+	RevokeLeasesOnShutdown bool `yaml:"revoke_leases_on_shutdown"` // Revoke the leases of dynamic credentials when the daemon stops
+	WatchEvents            bool `yaml:"watch_events"`              // Subscribe to Vault's event notifications to learn of secret rotations as they happen, which restart_units, reload_units and on_rotate of secrets on sockets rely on (Vault 1.13+)
+	PrefetchBeforeReady    bool `yaml:"prefetch_before_ready"`     // Fetch every secret once before notifying systemd of readiness, failing if any can't be
+	FDStore                bool `yaml:"fd_store"`                  // Keep sockets in systemd's file descriptor store, so they survive restarts (needs FileDescriptorStoreMax=)
+	WatchConfig            bool `yaml:"watch_config"`              // Reload the configuration when its file changes, as when pushed by configuration management
+
+	ConnectionTimeout       time.Duration `yaml:"connection_timeout"`         // How long a connection may take to be served, including fetching from Vault (default: 1m)
+	MaxConnectionsPerSocket int           `yaml:"max_connections_per_socket"` // The most connections served at once on each socket (default: 16)
+	MaxConnections          int           `yaml:"max_connections"`            // The most connections served at once across all sockets (optional)
+	ConnectionOverflow      string        `yaml:"connection_overflow"`        // Whether connections beyond the limits wait (queue, the default) or are closed (refuse)
+	DrainTimeout            time.Duration `yaml:"drain_timeout"`              // How long shutdown waits for connections being served to finish (default: 10s)
+
+	Webhooks              []Webhook `yaml:"webhooks"`                // HTTP endpoints notified of secret rotations and repeated fetch failures
+	FetchFailureThreshold int       `yaml:"fetch_failure_threshold"` // How many times in a row a secret must fail to be fetched before webhooks are notified (default: 3)
+
+	Include     []string       `yaml:"include"`  // Further configuration files merged in, by path or glob relative to this one, e.g. secrets kept by other teams
+	Defaults    *Secret        `yaml:"defaults"` // Settings every secret has unless it sets them itself, e.g. mount, field or mode
+	Secrets     []Secret       `yaml:"secrets"`
+	SecretsFrom *RemoteSecrets `yaml:"secrets_from"` // A KV secret holding further secrets, managed centrally in Vault
+	Discover    []Discovery    `yaml:"discover"`     // KV prefixes whose secrets are each served on a socket
+
+	CredentialSocket *CredentialSocket `yaml:"credential_socket"` // A single socket serving secrets by the name of the credential systemd loads
+	CredStore        *CredStore        `yaml:"credstore"`         // A directory of credential files for ImportCredential=, kept up to date
+
+	Files       []Secret      `yaml:"files"`        // Secrets maintained in files, e.g. on a tmpfs, for consumers that can't use sockets or credentials
+	FileRefresh time.Duration `yaml:"file_refresh"` // How often secrets in files are fetched again, to pick up rotations (default: 5m)
 }
 
 type Secret struct {
+	Type       string `yaml:"type"`        // The kind of secret: kv (the default), logical to read any Vault path, cubbyhole, pki, database, ssh, totp, aws, gcp, azure, consul, nomad, rabbitmq, ldap, transit, datakey, or oidc
 	VaultPath  string `yaml:"vault_path"`  // The path in Vault to the secret value
-	SocketPath string `yaml:"socket_path"` // The relative path to SocketRoot where the socket will be created
+	Mount      string `yaml:"mount"`       // The Secret Mount to read the secret from (optional, defaults to vault_mount)
+	SocketPath string `yaml:"socket_path"` // The relative path to SocketRoot where the socket will be created, or an abstract socket address beginning with @
+	VSockPort  uint32 `yaml:"vsock_port"`  // Serve to virtual machine guests on this AF_VSOCK port instead of a Unix socket (optional)
+	Owner      string `yaml:"owner"`       // The user, by name or ID, to own the socket (optional, defaults to the daemon's)
+	Group      string `yaml:"group"`       // The group, by name or ID, to own the socket (optional, defaults to the daemon's)
+	Mode       string `yaml:"mode"`        // The octal permissions of the socket, e.g. "0660" (optional, defaults to socket_mode)
+	FDName     string `yaml:"fd_name"`     // The FileDescriptorName= of a socket passed by systemd to serve the secret on instead (optional, defaults to socket_path)
 	Field      string `yaml:"field"`       // The field within the Vault secret to be returned (optional)
+	Format     string `yaml:"format"`      // How to render all fields when no field is set: json, env, or credentials for a shared credentials file from aws (optional)
+	Template   string `yaml:"template"`    // A Go text/template rendering the secret's fields, instead of field or format (optional)
+	Identity   string `yaml:"identity"`    // The named identity to fetch the secret with (optional, defaults to auth)
+	Namespace  string `yaml:"namespace"`   // The Vault Enterprise namespace to fetch the secret from (optional, defaults to that of its identity)
+	Cluster    string `yaml:"cluster"`     // The named cluster to fetch the secret from (optional, defaults to vault_server)
+	KVVersion  int    `yaml:"kv_version"`  // Overrides the KV engine version for this secret (optional)
+	Version    int    `yaml:"version"`     // Pins the KV v2 version of the secret served, e.g. during a staged rotation (optional, defaults to the latest)
+	WrapTTL    string `yaml:"wrap_ttl"`    // Serve a response wrapping token with this TTL in place of the secret (optional)
+	Metadata   string `yaml:"metadata"`    // Serve KV v2 metadata as JSON alongside the value (json), or on a companion <socket_path>.meta socket (socket) (optional)
+	Encrypt    string `yaml:"encrypt"`     // Serve the secret as a systemd encrypted credential, for LoadCredentialEncrypted=, sealed with this key: host, tpm2, host+tpm2, or auto (optional)
+
+	Vault *Cluster `yaml:"vault"` // A Vault server of its own to fetch the secret from, e.g. one on a segregated network, set up as under clusters (optional)
+
+	FilePath   string   `yaml:"file_path"`  // The file a secret under files is written to, with its owner, group and mode (default: 0400)
+	Aliases    []string `yaml:"aliases"`    // Further socket paths to serve the secret on, e.g. under the credential names of other units (optional)
+	Units      []string `yaml:"units"`      // The units loading the secret as a credential, for generated drop-ins (optional, defaults to allowed_units)
+	Credential string   `yaml:"credential"` // The credential name the units load the secret as (optional, defaults to the socket file name without .sock)
+
+	AllowedUIDs  []uint32 `yaml:"allowed_uids"`  // Only serve processes running as these users, or as the allowed groups (optional)
+	AllowedGIDs  []uint32 `yaml:"allowed_gids"`  // Only serve processes running as these groups, or as the allowed users (optional)
+	AllowedUnits []string `yaml:"allowed_units"` // Only serve processes in these systemd units, e.g. postgresql.service (optional)
+	PID1Only     bool     `yaml:"pid1_only"`     // Only serve systemd itself loading the secret with LoadCredential=, not other processes (systemd --user in user mode)
+	AllowedCIDs  []uint32 `yaml:"allowed_cids"`  // Only serve guests with these vsock context IDs (optional)
+
+	ConnectionTimeout time.Duration `yaml:"connection_timeout"` // Overrides the global connection_timeout for this socket (optional)
+	MaxConnections    int           `yaml:"max_connections"`    // Overrides max_connections_per_socket for this socket (optional)
+
+	RestartUnits []string `yaml:"restart_units"` // Units restarted, if running, when the secret is rotated (optional; on sockets, needs watch_events)
+	ReloadUnits  []string `yaml:"reload_units"`  // Units reloaded, or restarted if they can't be, when the secret is rotated (optional; on sockets, needs watch_events)
+	OnRotate     string   `yaml:"on_rotate"`     // A shell command run when the secret is rotated, with $SECRET_NAME, $SECRET_PATH and $SECRET_VERSION set (optional; on sockets, needs watch_events)
+
+	ServeOnce        bool `yaml:"serve_once"`         // Stop serving and remove the socket after the secret is first read, e.g. for bootstrap credentials
+	RevokeAfterServe bool `yaml:"revoke_after_serve"` // With serve_once, also revoke the lease of the credentials served
+
+	Required *bool `yaml:"required"` // Fetch the secret at startup, failing to start if it can't be (true), or only log failures to prefetch it (false) (optional)
+
+	Params map[string]interface{} `yaml:"params"` // Request parameters passed to Vault when fetching the secret, e.g. ttl (optional)
+	Write  bool                   `yaml:"write"`  // Fetch a logical secret by writing params to vault_path, rather than reading it
+
+	metadataOnly bool // Serve only the metadata, on a companion socket
+
+	PKI      *PKISecret      `yaml:"pki"`      // Certificate issuance options for pki secrets
+	Database *DatabaseSecret `yaml:"database"` // Credential options for database secrets
+	SSH      *SSHSecret      `yaml:"ssh"`      // Signing options for ssh secrets
+	TOTP     *TOTPSecret     `yaml:"totp"`     // Code generation options for totp secrets
+	AWS      *AWSSecret      `yaml:"aws"`      // Credential options for aws secrets
+	GCP      *GCPSecret      `yaml:"gcp"`      // Credential options for gcp secrets
+	Azure    *AzureSecret    `yaml:"azure"`    // Credential options for azure secrets
+	Consul   *ConsulSecret   `yaml:"consul"`   // Token options for consul secrets
+	Nomad    *NomadSecret    `yaml:"nomad"`    // Token options for nomad secrets
+	RabbitMQ *RabbitMQSecret `yaml:"rabbitmq"` // Credential options for rabbitmq secrets
+	LDAP     *LDAPSecret     `yaml:"ldap"`     // Credential options for ldap secrets
+	Transit  *TransitSecret  `yaml:"transit"`  // Key options for transit and datakey secrets
+	OIDC     *OIDCSecret     `yaml:"oidc"`     // Token options for oidc secrets
+}
+
+// abstract reports whether the secret is served on an abstract socket, which
+// exists outside the filesystem and so is not subject to its permissions.
+func (s Secret) abstract() bool {
+	return strings.HasPrefix(s.SocketPath, "@")
+}
+
+// onFilesystem reports whether the secret's socket is a file beneath
+// socket_root, rather than an abstract or vsock socket.
+func (s Secret) onFilesystem() bool {
+	return !s.abstract() && s.VSockPort == 0
+}
+
+// credentialName returns the name units load the secret as a credential by.
+func (s Secret) credentialName() string {
+	if s.Credential != "" {
+		return s.Credential
+	}
+	return strings.TrimSuffix(filepath.Base(s.SocketPath), ".sock")
+}
+
+// aliasSockets adds a socket serving each secret at each of its aliases.
+func aliasSockets(secrets []Secret) []Secret {
+	for _, secret := range secrets {
+		for _, alias := range secret.Aliases {
+			aliased := secret
+			aliased.SocketPath, aliased.FDName, aliased.Aliases = alias, "", nil
+			aliased.Credential = ""
+			aliased.VSockPort = 0
+			secrets = append(secrets, aliased)
+		}
+	}
+	return secrets
+}
+
+// required reports whether the secret must be fetched for the daemon to start.
+func (s Secret) required() bool {
+	return s.Required != nil && *s.Required
+}
+
+// optional reports whether failing to fetch the secret ahead of time is only
+// logged.
+func (s Secret) optional() bool {
+	return s.Required != nil && !*s.Required
 }
 
-func newConfig(path string) (*Config, error) {
+// role returns the engine role, or key, configured for the secret's type.
+func (s Secret) role() string {
+	switch {
+	case s.Type == "pki" && s.PKI != nil:
+		return s.PKI.Role
+	case s.Type == "database" && s.Database != nil:
+		return s.Database.Role
+	case s.Type == "ssh" && s.SSH != nil:
+		return s.SSH.Role
+	case s.Type == "totp" && s.TOTP != nil:
+		return s.TOTP.Key
+	case s.Type == "aws" && s.AWS != nil:
+		return s.AWS.Role
+	case s.Type == "gcp" && s.GCP != nil:
+		return s.GCP.Roleset
+	case s.Type == "azure" && s.Azure != nil:
+		return s.Azure.Role
+	case s.Type == "consul" && s.Consul != nil:
+		return s.Consul.Role
+	case s.Type == "nomad" && s.Nomad != nil:
+		return s.Nomad.Role
+	case s.Type == "rabbitmq" && s.RabbitMQ != nil:
+		return s.RabbitMQ.Role
+	case s.Type == "ldap" && s.LDAP != nil:
+		return s.LDAP.Role
+	case (s.Type == "transit" || s.Type == "datakey") && s.Transit != nil:
+		return s.Transit.Key
+	case s.Type == "oidc" && s.OIDC != nil:
+		return s.OIDC.Role
+	}
+	return ""
+}
+
+// newConfig reads the configuration from the file path, merged with the
+// files it includes and the files in dir, if given. Without the file, it is
+// read from environment variables instead, if any are set.
+func newConfig(path, dir string) (*Config, error) {
+	loader := &configLoader{checks: newConfigChecks(), read: map[string]bool{}}
+	config := &Config{}
+	if _, statErr := os.Stat(path); os.IsNotExist(statErr) && envConfigured() {
+		log.Printf("No configuration file %s, reading the configuration from %s environment variables", path, envPrefix)
+		var err error
+		config, err = loadEnvConfig(loader.checks)
+		if errs, ok := err.(configErrors); ok {
+			loader.problems = append(loader.problems, errs...)
+		} else if err != nil {
+			return nil, err
+		}
+		includes := config.Include
+		config.Include = nil
+		if err = loader.include(config, "environment", includes); err != nil {
+			return nil, err
+		}
+	} else if err := loader.merge(config, path); err != nil {
+		return nil, err
+	}
+
+	if dir != "" {
+		fragments, err := configFragments(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fragment := range fragments {
+			if err = loader.merge(config, fragment); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(loader.problems) > 0 {
+		return nil, errors.Wrap(loader.problems, "parsing configuration yaml")
+	}
+
+	config.applyDefaults()
+	if err := config.vaultProfiles(); err != nil {
+		return nil, err
+	}
+	config.Secrets = metadataSockets(aliasSockets(config.Secrets))
+	if config.SocketRoot == "" {
+		config.SocketRoot = defaultSocketRoot()
+	}
+
+	return config, nil
+}
+
+// configLoader reads a configuration from the files it is spread over,
+// collecting the problems found in all of them.
+type configLoader struct {
+	checks   *configChecks
+	problems configErrors
+	read     map[string]bool // The files read so far, so none is read twice
+}
+
+// merge reads the file path, and the files it includes, into config.
+func (l *configLoader) merge(config *Config, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if l.read[abs] {
+		return errors.Errorf("configuration file %s is included more than once", path)
+	}
+	l.read[abs] = true
+
+	merged, err := loadConfigFile(path, l.checks)
+	if errs, ok := err.(configErrors); ok {
+		l.problems = append(l.problems, errs...)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	includes := merged.Include
+	merged.Include = nil
+	if err = mergeConfig(config, merged); err != nil {
+		return errors.Wrapf(err, "merging %s", path)
+	}
+	return l.include(config, path, includes)
+}
+
+// include reads the files matching patterns, relative to the directory of
+// the file path including them, into config.
+func (l *configLoader) include(config *Config, path string, patterns []string) error {
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "including %s from %s", pattern, path)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return errors.Errorf("configuration file %s included from %s does not exist", pattern, path)
+		}
+		for _, match := range matches {
+			if err = l.merge(config, match); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadConfigFile reads and validates the configuration in the file path. A
+// configuration with problems is returned along with them, as configErrors.
+func loadConfigFile(path string, checks *configChecks) (*Config, error) {
 	config := &Config{}
 	content, err := ioutil.ReadFile(path) // the file is inside the local directory
 	if err != nil {
 		return nil, errors.Wrap(err, "opening config file")
 	}
 
-	err = yaml.Unmarshal(content, config)
+	if content, err = expandEnv(content); err != nil {
+		if errs, ok := err.(configErrors); ok {
+			for i := range errs {
+				errs[i] = path + " " + errs[i]
+			}
+		}
+		return config, err
+	}
+	content, converted, err := configYAML(path, content)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing configuration yaml")
+		return nil, errors.Wrapf(err, "reading %s", path)
 	}
+	// Lines of converted YAML would only mislead
+	return config, config.validate(path, content, !converted, yaml.UnmarshalStrict(content, config), checks)
+}
 
-	return config, nil
+// envReference matches ${NAME} and ${NAME:-default} in configuration, or
+// either escaped as $${...} to be left for something else to expand, such as
+// the shell running an on_rotate hook.
+var envReference = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv replaces references to environment variables in content, so
+// that one configuration can serve several environments through
+// EnvironmentFile=. A variable that isn't set and has no default is an error.
+func expandEnv(content []byte) ([]byte, error) {
+	var expanded []byte
+	var problems configErrors
+	last := 0
+	for _, m := range envReference.FindAllSubmatchIndex(content, -1) {
+		expanded = append(expanded, content[last:m[0]]...)
+		last = m[1]
+		lineStart := bytes.LastIndexByte(content[:m[0]], '\n') + 1
+		if inComment(content[lineStart:m[0]]) {
+			expanded = append(expanded, content[m[0]:m[1]]...)
+			continue
+		}
+		if m[3] > m[2] {
+			// Escaped: drop the extra $
+			expanded = append(expanded, content[m[0]+1:m[1]]...)
+			continue
+		}
+
+		name := string(content[m[4]:m[5]])
+		value, ok := os.LookupEnv(name)
+		switch {
+		case ok:
+			expanded = append(expanded, value...)
+		case m[6] >= 0:
+			expanded = append(expanded, content[m[6]:m[7]]...)
+		default:
+			line := 1 + bytes.Count(content[:m[0]], []byte("\n"))
+			problems = append(problems, fmt.Sprintf("line %d: environment variable %s is not set", line, name))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return append(expanded, content[last:]...), nil
+}
+
+// inComment reports whether the end of line is within a YAML comment, which
+// begins with a # at the start of the line or after whitespace.
+func inComment(line []byte) bool {
+	for i, c := range line {
+		if c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return true
+		}
+	}
+	return false
+}
 
+// configExtensions are those of the files read from a configuration directory.
+var configExtensions = []string{".yml", ".yaml", ".json", ".toml", ".hcl"}
+
+// configFragments returns the configuration files in dir, in name order.
+func configFragments(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading configuration directory")
+	}
+
+	var fragments []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		for _, ext := range configExtensions {
+			if strings.ToLower(filepath.Ext(entry.Name())) == ext {
+				fragments = append(fragments, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return fragments, nil
+}
+
+// mergeConfig merges the configuration from a file in the configuration
+// directory into config. Lists are appended to, named entries added, and
+// other settings replaced.
+func mergeConfig(config, from *Config) error {
+	into, merged := reflect.ValueOf(config).Elem(), reflect.ValueOf(from).Elem()
+	for i := 0; i < into.NumField(); i++ {
+		value := merged.Field(i)
+		if value.IsZero() || !into.Field(i).CanSet() {
+			continue
+		}
+
+		name := strings.Split(into.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		field := into.Field(i)
+		switch value.Kind() {
+		case reflect.Slice:
+			field.Set(reflect.AppendSlice(field, value))
+		case reflect.Map:
+			if field.IsNil() {
+				field.Set(reflect.MakeMap(field.Type()))
+			}
+			for _, key := range value.MapKeys() {
+				if field.MapIndex(key).IsValid() {
+					return errors.Errorf("%s %v is already configured", name, key)
+				}
+				field.SetMapIndex(key, value.MapIndex(key))
+			}
+		default:
+			field.Set(value)
+		}
+	}
+	return nil
 }
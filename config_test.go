@@ -0,0 +1,266 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("SCV_TEST_SERVER", "https://vault.example:8200")
+	t.Setenv("SCV_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "set",
+			content: "vault_server: ${SCV_TEST_SERVER}\n",
+			want:    "vault_server: https://vault.example:8200\n",
+		},
+		{
+			name:    "set but empty",
+			content: "vault_mount: ${SCV_TEST_EMPTY:-kv}\n",
+			want:    "vault_mount: \n",
+		},
+		{
+			name:    "default",
+			content: "vault_mount: ${SCV_TEST_UNSET:-kv}\n",
+			want:    "vault_mount: kv\n",
+		},
+		{
+			name:    "empty default",
+			content: "vault_mount: ${SCV_TEST_UNSET:-}\n",
+			want:    "vault_mount: \n",
+		},
+		{
+			name:    "escaped",
+			content: "on_rotate: echo $${SCV_TEST_SERVER}\n",
+			want:    "on_rotate: echo ${SCV_TEST_SERVER}\n",
+		},
+		{
+			name:    "escaped with default",
+			content: "on_rotate: echo $${SCV_TEST_UNSET:-x}\n",
+			want:    "on_rotate: echo ${SCV_TEST_UNSET:-x}\n",
+		},
+		{
+			name:    "in a comment",
+			content: "# e.g. ${SCV_TEST_UNSET}\nvault_mount: kv # or ${SCV_TEST_UNSET}\n",
+			want:    "# e.g. ${SCV_TEST_UNSET}\nvault_mount: kv # or ${SCV_TEST_UNSET}\n",
+		},
+		{
+			name:    "hash within a value",
+			content: "vault_path: a#${SCV_TEST_UNSET:-b}\n",
+			want:    "vault_path: a#b\n",
+		},
+		{
+			name:    "not a reference",
+			content: "vault_path: $SCV_TEST_SERVER and ${1X}\n",
+			want:    "vault_path: $SCV_TEST_SERVER and ${1X}\n",
+		},
+		{
+			name:    "unset",
+			content: "vault_mount: kv\nvault_server: ${SCV_TEST_UNSET}\n",
+			wantErr: "line 2: environment variable SCV_TEST_UNSET is not set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandEnv([]byte(tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expandEnv() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandEnv() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("expandEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	server := "https://vault.example:8200"
+	other := "https://other.example:8200"
+
+	tests := []struct {
+		name    string
+		config  Config
+		from    Config
+		want    Config
+		wantErr string
+	}{
+		{
+			name:   "lists appended",
+			config: Config{Secrets: []Secret{{SocketPath: "a.sock"}}},
+			from:   Config{Secrets: []Secret{{SocketPath: "b.sock"}}},
+			want:   Config{Secrets: []Secret{{SocketPath: "a.sock"}, {SocketPath: "b.sock"}}},
+		},
+		{
+			name:   "named entries added",
+			config: Config{Clusters: map[string]*Cluster{"a": {Address: server}}},
+			from:   Config{Clusters: map[string]*Cluster{"b": {Address: other}}},
+			want:   Config{Clusters: map[string]*Cluster{"a": {Address: server}, "b": {Address: other}}},
+		},
+		{
+			name: "named entries into none",
+			from: Config{Clusters: map[string]*Cluster{"b": {Address: other}}},
+			want: Config{Clusters: map[string]*Cluster{"b": {Address: other}}},
+		},
+		{
+			name:    "named entry already configured",
+			config:  Config{Clusters: map[string]*Cluster{"a": {Address: server}}},
+			from:    Config{Clusters: map[string]*Cluster{"a": {Address: other}}},
+			wantErr: "clusters a is already configured",
+		},
+		{
+			name:   "settings replaced",
+			config: Config{VaultServer: &server, VaultMount: "kv", KVVersion: 1},
+			from:   Config{VaultServer: &other, VaultMount: "secret"},
+			want:   Config{VaultServer: &other, VaultMount: "secret", KVVersion: 1},
+		},
+		{
+			name:   "unset settings kept",
+			config: Config{VaultMount: "kv", OpenBao: true},
+			from:   Config{},
+			want:   Config{VaultMount: "kv", OpenBao: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mergeConfig(&tt.config, &tt.from)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("mergeConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(tt.config, tt.want) {
+				t.Errorf("mergeConfig() = %+v, want %+v", tt.config, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigInclude(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string // Files beside config.yml, by name
+		include string            // What config.yml includes
+		want    []string          // The socket paths of the secrets read
+		wantErr string
+	}{
+		{
+			name:    "file",
+			files:   map[string]string{"a.yml": "secrets: [{vault_path: a, socket_path: a.sock}]"},
+			include: "[a.yml]",
+			want:    []string{"main.sock", "a.sock"},
+		},
+		{
+			name: "nested",
+			files: map[string]string{
+				"a.yml":     "include: [sub/b.yml]\nsecrets: [{vault_path: a, socket_path: a.sock}]",
+				"sub/b.yml": "secrets: [{vault_path: b, socket_path: b.sock}]",
+			},
+			include: "[a.yml]",
+			want:    []string{"main.sock", "a.sock", "b.sock"},
+		},
+		{
+			name: "pattern",
+			files: map[string]string{
+				"conf/b.yml": "secrets: [{vault_path: b, socket_path: b.sock}]",
+				"conf/a.yml": "secrets: [{vault_path: a, socket_path: a.sock}]",
+			},
+			include: "[conf/*.yml]",
+			want:    []string{"main.sock", "a.sock", "b.sock"},
+		},
+		{
+			name:    "pattern matching nothing",
+			include: "[conf/*.yml]",
+			want:    []string{"main.sock"},
+		},
+		{
+			name:    "missing file",
+			include: "[a.yml]",
+			wantErr: "a.yml included from",
+		},
+		{
+			name:    "itself",
+			include: "[config.yml]",
+			wantErr: "config.yml is included more than once",
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"a.yml": "include: [b.yml]",
+				"b.yml": "include: [a.yml]",
+			},
+			include: "[a.yml]",
+			wantErr: "a.yml is included more than once",
+		},
+		{
+			name: "included twice",
+			files: map[string]string{
+				"a.yml": "include: [c.yml]",
+				"b.yml": "include: [c.yml]",
+				"c.yml": "secrets: [{vault_path: c, socket_path: c.sock}]",
+			},
+			include: "[a.yml, b.yml]",
+			wantErr: "c.yml is included more than once",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{
+				"config.yml": "vault_mount: kv\ninclude: " + tt.include + "\nsecrets: [{vault_path: main, socket_path: main.sock}]\n",
+			}
+			for name, content := range tt.files {
+				files[name] = content
+			}
+			for name, content := range files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config, err := newConfig(filepath.Join(dir, "config.yml"), "")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newConfig() error = %v", err)
+			}
+			var got []string
+			for _, secret := range config.Secrets {
+				got = append(got, secret.SocketPath)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newConfig() read secrets %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// configureConsistency sets how client avoids stale reads from the
+// performance standbys of an integrated storage (Raft) cluster:
+//
+//   - read_your_writes sends the X-Vault-Index of the client's last write
+//     with each request, retrying until the node handling it has caught up.
+//   - forward_inconsistent does the same, but has a node that hasn't caught
+//     up forward the request to the active node instead of retrying.
+//   - forward_always has every request forwarded to the active node, so
+//     rotations made by anyone are seen straight away. This must be enabled
+//     in Vault's configuration.
+func configureConsistency(client *api.Client, mode string) error {
+	switch mode {
+	case "":
+	case "read_your_writes":
+		client.SetReadYourWrites(true)
+	case "forward_inconsistent":
+		client.SetReadYourWrites(true)
+		client.AddHeader(api.HeaderInconsistent, "forward-active-node")
+	case "forward_always":
+		client.AddHeader(api.HeaderForward, "active-node")
+	default:
+		return errors.Errorf("unsupported consistency %q", mode)
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+package main
+
+type ConsulSecret struct {
+	Role string `yaml:"role"` // The Consul role to generate tokens for
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// controlTimeout bounds how long a control connection may take.
+const controlTimeout = 30 * time.Second
+
+// listenControl listens on the administrative control socket, accepting a
+// command on each connection until ctx is cancelled.
+func (app *App) listenControl(ctx context.Context) error {
+	path := app.config.ControlSocket
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	if err := app.createSocketDir(path); err != nil {
+		return err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return errors.Wrap(err, "listening on control socket")
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return errors.Wrap(err, "changing mode of control socket")
+	}
+	log.Printf("Accepting commands on %s", path)
+
+	go acceptConns(ctx, ln, path, controlTimeout, app.newConnLimits(1), func(c net.Conn) {
+		defer c.Close()
+		if err := app.authorizeControl(c); err != nil {
+			logWarning("Refusing control connection: %+v", err)
+			fmt.Fprintf(c, "error: %v\n", err)
+			return
+		}
+		line, err := bufio.NewReader(c).ReadString('\n')
+		if err != nil && err != io.EOF {
+			logError("Error reading control command: %+v", err)
+			return
+		}
+		if err = app.runControl(ctx, strings.Fields(line), c); err != nil {
+			fmt.Fprintf(c, "error: %v\n", err)
+		}
+	})
+	return nil
+}
+
+// authorizeControl checks that the process connected on c runs as one of the
+// users allowed to control the daemon: control_uids, or by default root and
+// the daemon's own user.
+func (app *App) authorizeControl(c net.Conn) error {
+	cred, err := peerCredentials(c)
+	if err != nil {
+		return errors.Wrap(err, "reading peer credentials")
+	}
+	return app.authorizeControlUID(cred.UID)
+}
+
+// authorizeControlUID checks that uid is one of the users allowed to control
+// the daemon.
+func (app *App) authorizeControlUID(uid uint32) error {
+	allowed := app.config.ControlUIDs
+	if len(allowed) == 0 {
+		allowed = []uint32{0, uint32(os.Getuid())}
+	}
+	if !containsID(allowed, uid) {
+		return errors.Errorf("uid %d is not allowed", uid)
+	}
+	return nil
+}
+
+// runControl runs a control command, writing its output to w.
+func (app *App) runControl(ctx context.Context, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+
+	switch args[0] {
+	case "list":
+		for _, secret := range append(app.servedSecrets(), app.discoveredSecrets()...) {
+			fmt.Fprintf(w, "%s\t%s\n", app.socketAddress(secret), app.readPath(secret))
+		}
+
+	case "reload":
+		log.Print("Reload requested on control socket")
+		app.reloadConfig(ctx)
+		fmt.Fprintln(w, "reloaded")
+
+	case "flush":
+		app.flushCaches()
+		log.Print("Flushed cached secrets")
+		fmt.Fprintln(w, "flushed")
+
+	case "refresh":
+		if len(args) != 2 {
+			return errors.New("usage: refresh <socket path or credential>")
+		}
+		secret, ok := app.findSecret(args[1])
+		if !ok {
+			return errors.Errorf("no secret %s", args[1])
+		}
+		app.flushCaches(secret.SocketPath)
+		app.refreshFiles(secret)
+		log.Printf("Refreshing secret %s", app.readPath(secret))
+		fmt.Fprintf(w, "refreshing %s\n", app.readPath(secret))
+
+	case "status":
+		health := "healthy"
+		if err := app.checkHealth(ctx); err != nil {
+			health = "unhealthy: " + err.Error()
+		}
+		app.leases.Lock()
+		leases := len(app.leases.leases)
+		app.leases.Unlock()
+		fmt.Fprintf(w, "status: %s\nsecrets: %d\nleases: %d\n", health, len(app.servedSecrets())+len(app.discoveredSecrets()), leases)
+
+	default:
+		return errors.Errorf("unknown command %q", args[0])
+	}
+	return nil
+}
+
+// findSecret returns the secret served on a socket path, or as a credential
+// or file, by that name.
+func (app *App) findSecret(name string) (Secret, bool) {
+	secrets := append(app.servedSecrets(), app.discoveredSecrets()...)
+	if app.config.CredStore != nil {
+		secrets = append(secrets, app.config.CredStore.secrets()...)
+	}
+	secrets = append(secrets, fileSecrets(app.config.Files)...)
+
+	for _, secret := range secrets {
+		if secret.SocketPath == name || secret.FilePath == name || secret.credentialName() == name {
+			return secret, true
+		}
+	}
+	return Secret{}, false
+}
+
+// runCtl implements the ctl subcommand, which sends a command to the running
+// daemon's control socket and prints its response.
+func runCtl(config *Config, args []string) error {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("no command given: list, reload, flush, refresh <secret>, or status")
+	}
+	if config.ControlSocket == "" {
+		return errors.New("no control_socket configured")
+	}
+
+	c, err := net.DialTimeout("unix", config.ControlSocket, controlTimeout)
+	if err != nil {
+		return errors.Wrap(err, "connecting to control socket")
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(controlTimeout))
+
+	if _, err = fmt.Fprintln(c, strings.Join(flags.Args(), " ")); err != nil {
+		return errors.Wrap(err, "sending command")
+	}
+	response, err := ioutil.ReadAll(c)
+	if err != nil {
+		return errors.Wrap(err, "reading response")
+	}
+	os.Stdout.Write(response)
+	if strings.HasPrefix(string(response), "error:") {
+		return errors.New("command failed")
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/pkg/errors"
+)
+
+// CredentialSocket is a single socket serving many secrets, selected by the
+// name of the credential systemd is loading. For LoadCredential= and
+// LoadCredentialEncrypted= with an AF_UNIX socket path, systemd binds the
+// connecting socket to the abstract address "\0<random>/unit/<unit>/<name>".
+type CredentialSocket struct {
+	SocketPath  string            `yaml:"socket_path"` // The relative path to SocketRoot where the socket will be created, or an abstract socket address beginning with @
+	FDName      string            `yaml:"fd_name"`     // The FileDescriptorName= of a socket passed by systemd to serve on instead (optional, defaults to socket_path)
+	Credentials map[string]Secret `yaml:"credentials"` // The secret served for each credential name
+	Rules       []CredentialRule  `yaml:"rules"`       // Rules mapping other credential names to secrets, tried in order
+
+	// PID1Only refuses connections from anything but systemd. Any local
+	// process able to connect could otherwise bind to an address naming
+	// another unit and credential.
+	PID1Only bool `yaml:"pid1_only"`
+
+	MaxConnections int `yaml:"max_connections"` // Overrides max_connections_per_socket for this socket (optional)
+}
+
+// CredentialRule maps the credentials matching a pattern to secrets. The
+// secret's vault_path, mount and field may refer to {unit}, the requesting
+// unit, {credential}, the credential name, and the pattern's placeholders or
+// named groups, so that new services can be given credentials without
+// configuring each one.
+type CredentialRule struct {
+	Credential string `yaml:"credential"` // A credential name pattern with {placeholders}, e.g. db_{name}
+	Regex      string `yaml:"regex"`      // A regular expression with named groups, instead of credential
+	Secret     Secret `yaml:"secret"`     // The secret served, e.g. vault_path: apps/{unit}/{name}
+
+	pattern *regexp.Regexp
+}
+
+var placeholderPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// compile prepares the rule's pattern for matching.
+func (r *CredentialRule) compile() error {
+	expr := r.Regex
+	if expr == "" {
+		if r.Credential == "" {
+			return errors.New("credential rules require a credential pattern or regex")
+		}
+		// Each {placeholder} matches up to the next literal part of the
+		// pattern, and never across a /
+		var b strings.Builder
+		last := 0
+		for _, m := range placeholderPattern.FindAllStringSubmatchIndex(r.Credential, -1) {
+			b.WriteString(regexp.QuoteMeta(r.Credential[last:m[0]]))
+			b.WriteString("(?P<" + r.Credential[m[2]:m[3]] + ">[^/]+?)")
+			last = m[1]
+		}
+		b.WriteString(regexp.QuoteMeta(r.Credential[last:]))
+		expr = "^" + b.String() + "$"
+	}
+
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return errors.Wrapf(err, "compiling credential rule %s", expr)
+	}
+	r.pattern = pattern
+	return nil
+}
+
+// match returns the secret the rule maps a credential to, if it matches.
+func (r *CredentialRule) match(peer credentialPeer) (Secret, bool) {
+	m := r.pattern.FindStringSubmatch(peer.Name)
+	if m == nil {
+		return Secret{}, false
+	}
+
+	values := map[string]string{"unit": peer.Unit, "credential": peer.Name}
+	for i, name := range r.pattern.SubexpNames() {
+		if name != "" {
+			values[name] = m[i]
+		}
+	}
+	expand := func(s string) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(p string) string {
+			if v, ok := values[p[1:len(p)-1]]; ok {
+				return v
+			}
+			return p
+		})
+	}
+
+	secret := r.Secret
+	secret.VaultPath = expand(secret.VaultPath)
+	secret.Mount = expand(secret.Mount)
+	secret.Field = expand(secret.Field)
+	return secret, true
+}
+
+// credentialPeer is the unit and credential name systemd encodes in the peer
+// address when loading a credential from a socket.
+type credentialPeer struct {
+	Unit string
+	Name string
+}
+
+// parseCredentialPeer parses the peer address of a connection made by
+// systemd to load a credential.
+func parseCredentialPeer(addr net.Addr) (credentialPeer, error) {
+	unixAddr, ok := addr.(*net.UnixAddr)
+	if !ok || !strings.HasPrefix(unixAddr.Name, "@") {
+		return credentialPeer{}, errors.Errorf("peer address %v is not that of systemd loading a credential", addr)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(unixAddr.Name, "@"), "/", 4)
+	if len(parts) != 4 || parts[1] != "unit" || parts[2] == "" || parts[3] == "" {
+		return credentialPeer{}, errors.Errorf("peer address %s is not that of systemd loading a credential", unixAddr.Name)
+	}
+	// Credential names end up in vault paths, so can't be allowed to walk them
+	if strings.Contains(parts[3], "/") || strings.Contains(parts[3], "..") {
+		return credentialPeer{}, errors.Errorf("credential name %q can't contain / or ..", parts[3])
+	}
+	return credentialPeer{Unit: parts[2], Name: parts[3]}, nil
+}
+
+// verifyCredentialPeer checks that the unit named in the peer address of c
+// is the one the process connected runs in. Any process can bind an address
+// naming another unit, so only systemd, loading the credential for a unit it
+// is starting, is taken at its word.
+func verifyCredentialPeer(c net.Conn, peer credentialPeer) error {
+	cred, err := peerCredentials(c)
+	if err != nil {
+		return errors.Wrap(err, "reading peer credentials")
+	}
+	if cred.PID == managerPID() {
+		return nil
+	}
+
+	unit, err := processUnit(cred.PID)
+	if err != nil {
+		return errors.Wrapf(err, "resolving unit of process %d", cred.PID)
+	}
+	if unit != peer.Unit {
+		return errors.Errorf("process %d in unit %s claims to load a credential for %s", cred.PID, unit, peer.Unit)
+	}
+	return nil
+}
+
+// compile prepares the socket's rules, reporting any that are invalid.
+func (s *CredentialSocket) compile() error {
+	for i := range s.Rules {
+		if err := s.Rules[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secret returns the secret served for a credential name.
+func (s *CredentialSocket) secret(name string) (Secret, bool) {
+	secret, ok := s.Credentials[name]
+	if !ok {
+		return secret, false
+	}
+	// Keeps cached certificates and leases apart from other credentials
+	secret.SocketPath = s.SocketPath + "#" + name
+	secret.Credential = name
+	return secret, true
+}
+
+// secretFor returns the secret served for the credential peer is loading:
+// the one configured for its name, or else that of the first matching rule.
+func (s *CredentialSocket) secretFor(peer credentialPeer) (Secret, bool) {
+	if secret, ok := s.secret(peer.Name); ok {
+		return secret, true
+	}
+	for i := range s.Rules {
+		if secret, ok := s.Rules[i].match(peer); ok {
+			secret.SocketPath = s.SocketPath + "#" + peer.Unit + "/" + peer.Name
+			secret.Credential = peer.Name
+			return secret, true
+		}
+	}
+	return Secret{}, false
+}
+
+// secrets returns the secrets served on the socket.
+func (s *CredentialSocket) secrets() []Secret {
+	secrets := make([]Secret, 0, len(s.Credentials))
+	for name := range s.Credentials {
+		secret, _ := s.secret(name)
+		secrets = append(secrets, secret)
+	}
+	return secrets
+}
+
+// socket returns a secret standing in for the socket itself, for listening.
+func (s *CredentialSocket) socket() Secret {
+	return Secret{SocketPath: s.SocketPath, FDName: s.FDName}
+}
+
+// serveCredentialSocket serves each connection to ln the secret for the
+// credential being loaded, until ctx is cancelled.
+func (app *App) serveCredentialSocket(ctx context.Context, s *CredentialSocket, ln net.Listener) {
+	acceptConns(ctx, ln, s.SocketPath, app.connectionTimeout(), app.newConnLimits(s.MaxConnections), func(c net.Conn) {
+		defer c.Close()
+
+		if s.PID1Only {
+			if err := app.authorize(Secret{PID1Only: true}, c, ""); err != nil {
+				logWarning("Refusing connection to %s: %+v", s.SocketPath, err)
+				return
+			}
+		}
+
+		peer, err := parseCredentialPeer(c.RemoteAddr())
+		if err != nil {
+			logWarning("Refusing connection to %s: %+v", s.SocketPath, err)
+			return
+		}
+		if err = verifyCredentialPeer(c, peer); err != nil {
+			logWarning("Refusing connection to %s: %+v", s.SocketPath, err)
+			return
+		}
+		secret, ok := s.secretFor(peer)
+		if !ok {
+			logWarning("No secret configured for credential %s requested by %s", peer.Name, peer.Unit)
+			return
+		}
+		if err = app.authorize(secret, c, peer.Unit); err != nil {
+			app.logSecret(journal.PriWarning, secret, c, "Refusing to serve credential %s to %s: %+v", peer.Name, peer.Unit, err)
+			return
+		}
+
+		app.logSecret(journal.PriInfo, secret, c, "Serving secret value for %s as credential %s to %s", app.readPath(secret), peer.Name, peer.Unit)
+		if err = app.writeSecret(app.connCtx, secret, c); err != nil {
+			app.logSecret(journal.PriErr, secret, c, "Error serving credential %s to %s: %+v", peer.Name, peer.Unit, err)
+		}
+	})
+}
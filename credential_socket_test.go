@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseCredentialPeer(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    net.Addr
+		want    credentialPeer
+		wantErr bool
+	}{
+		{
+			name: "systemd",
+			addr: &net.UnixAddr{Name: "@f3a9c1d2e4b5a6c7/unit/postgresql.service/db_password", Net: "unix"},
+			want: credentialPeer{Unit: "postgresql.service", Name: "db_password"},
+		},
+		{
+			name: "template instance",
+			addr: &net.UnixAddr{Name: "@0123/unit/app@blue.service/token", Net: "unix"},
+			want: credentialPeer{Unit: "app@blue.service", Name: "token"},
+		},
+		{
+			name:    "slash in credential name",
+			addr:    &net.UnixAddr{Name: "@0123/unit/a.service/db_../../b.service/secret", Net: "unix"},
+			wantErr: true,
+		},
+		{
+			name:    "dots in credential name",
+			addr:    &net.UnixAddr{Name: "@0123/unit/app.service/db_..", Net: "unix"},
+			wantErr: true,
+		},
+		{
+			name:    "unnamed",
+			addr:    &net.UnixAddr{Name: "", Net: "unix"},
+			wantErr: true,
+		},
+		{
+			name:    "on the filesystem",
+			addr:    &net.UnixAddr{Name: "/run/client.sock", Net: "unix"},
+			wantErr: true,
+		},
+		{
+			name:    "not a unit",
+			addr:    &net.UnixAddr{Name: "@0123/user/app.service/token", Net: "unix"},
+			wantErr: true,
+		},
+		{
+			name:    "no credential name",
+			addr:    &net.UnixAddr{Name: "@0123/unit/app.service/", Net: "unix"},
+			wantErr: true,
+		},
+		{
+			name:    "no unit",
+			addr:    &net.UnixAddr{Name: "@0123/unit//token", Net: "unix"},
+			wantErr: true,
+		},
+		{
+			name:    "not unix",
+			addr:    &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCredentialPeer(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCredentialPeer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseCredentialPeer() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentialRule(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       CredentialRule
+		peer       credentialPeer
+		wantMatch  bool
+		wantSecret Secret
+	}{
+		{
+			name:       "placeholder",
+			rule:       CredentialRule{Credential: "db_{name}", Secret: Secret{VaultPath: "apps/{unit}/{name}"}},
+			peer:       credentialPeer{Unit: "app.service", Name: "db_orders"},
+			wantMatch:  true,
+			wantSecret: Secret{VaultPath: "apps/app.service/orders"},
+		},
+		{
+			name: "no match",
+			rule: CredentialRule{Credential: "db_{name}", Secret: Secret{VaultPath: "apps/{unit}/{name}"}},
+			peer: credentialPeer{Unit: "app.service", Name: "api_token"},
+		},
+		{
+			name: "whole name",
+			rule: CredentialRule{Credential: "db_{name}", Secret: Secret{VaultPath: "apps/{unit}/{name}"}},
+			peer: credentialPeer{Unit: "app.service", Name: "old_db_orders"},
+		},
+		{
+			name: "literal parts quoted",
+			rule: CredentialRule{Credential: "db.{name}", Secret: Secret{VaultPath: "{name}"}},
+			peer: credentialPeer{Unit: "app.service", Name: "dbXorders"},
+		},
+		{
+			name:       "placeholders split at literals",
+			rule:       CredentialRule{Credential: "{service}-{key}", Secret: Secret{VaultPath: "{service}", Field: "{key}"}},
+			peer:       credentialPeer{Unit: "app.service", Name: "billing-api-key"},
+			wantMatch:  true,
+			wantSecret: Secret{VaultPath: "billing", Field: "api-key"},
+		},
+		{
+			name: "placeholders don't match across a slash",
+			rule: CredentialRule{Credential: "db_{name}", Secret: Secret{VaultPath: "apps/{unit}/{name}"}},
+			peer: credentialPeer{Unit: "app.service", Name: "db_a/b"},
+		},
+		{
+			name:       "regex",
+			rule:       CredentialRule{Regex: `^(?P<env>prod|dev)_(?P<key>.+)$`, Secret: Secret{Mount: "{env}", VaultPath: "{unit}", Field: "{key}"}},
+			peer:       credentialPeer{Unit: "app.service", Name: "prod_token"},
+			wantMatch:  true,
+			wantSecret: Secret{Mount: "prod", VaultPath: "app.service", Field: "token"},
+		},
+		{
+			name:       "credential and unknown placeholders",
+			rule:       CredentialRule{Credential: "{name}", Secret: Secret{VaultPath: "creds/{credential}/{other}"}},
+			peer:       credentialPeer{Unit: "app.service", Name: "token"},
+			wantMatch:  true,
+			wantSecret: Secret{VaultPath: "creds/token/{other}"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.compile(); err != nil {
+				t.Fatalf("compile() error = %v", err)
+			}
+			secret, ok := tt.rule.match(tt.peer)
+			if ok != tt.wantMatch {
+				t.Fatalf("match() matched = %v, want %v", ok, tt.wantMatch)
+			}
+			if !reflect.DeepEqual(secret, tt.wantSecret) {
+				t.Errorf("match() = %+v, want %+v", secret, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestCredentialRuleCompileErrors(t *testing.T) {
+	for _, rule := range []CredentialRule{{}, {Regex: "db_(?P<name"}} {
+		if err := rule.compile(); err == nil {
+			t.Errorf("compile() of %+v succeeded", rule)
+		}
+	}
+}
+
+func TestCredentialSocketSecretFor(t *testing.T) {
+	s := &CredentialSocket{
+		SocketPath:  "credentials.sock",
+		Credentials: map[string]Secret{"db_orders": {VaultPath: "orders/db"}},
+		Rules:       []CredentialRule{{Credential: "db_{name}", Secret: Secret{VaultPath: "apps/{name}"}}},
+	}
+	if err := s.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		peer credentialPeer
+		want Secret
+		ok   bool
+	}{
+		{
+			peer: credentialPeer{Unit: "app.service", Name: "db_orders"},
+			want: Secret{VaultPath: "orders/db", SocketPath: "credentials.sock#db_orders", Credential: "db_orders"},
+			ok:   true,
+		},
+		{
+			peer: credentialPeer{Unit: "app.service", Name: "db_users"},
+			want: Secret{VaultPath: "apps/users", SocketPath: "credentials.sock#app.service/db_users", Credential: "db_users"},
+			ok:   true,
+		},
+		{
+			peer: credentialPeer{Unit: "app.service", Name: "token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.peer.Name, func(t *testing.T) {
+			secret, ok := s.secretFor(tt.peer)
+			if ok != tt.ok || !reflect.DeepEqual(secret, tt.want) {
+				t.Errorf("secretFor() = %+v, %v, want %+v, %v", secret, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// CredStore populates a directory with secrets as credential files, for units
+// to import with ImportCredential=, as an alternative to a socket per secret.
+type CredStore struct {
+	Directory          string            `yaml:"directory"`           // Where credentials are written (default: /run/credstore, or under $XDG_RUNTIME_DIR for systemd --user)
+	EncryptedDirectory string            `yaml:"encrypted_directory"` // Where credentials with encrypt set are written (default: /run/credstore.encrypted, or as for directory)
+	Refresh            time.Duration     `yaml:"refresh"`             // How often credentials are fetched again, to pick up rotations (default: 5m)
+	Credentials        map[string]Secret `yaml:"credentials"`         // The secret written for each credential name
+
+	rotated chan struct{} // Signalled when a secret is known to have been rotated
+}
+
+// secrets returns the secrets written to the credstore, named for their
+// credentials.
+func (s *CredStore) secrets() []Secret {
+	secrets := make([]Secret, 0, len(s.Credentials))
+	for name, secret := range s.Credentials {
+		// Keeps cached certificates and leases apart from those of sockets
+		secret.SocketPath = "credstore#" + name
+		secret.Credential = name
+		secrets = append(secrets, secret)
+	}
+	return secrets
+}
+
+// targets returns the credential files of the credstore's secrets, readable
+// only by systemd importing them.
+func (s *CredStore) targets() []fileTarget {
+	secrets := s.secrets()
+	targets := make([]fileTarget, 0, len(secrets))
+	for _, secret := range secrets {
+		targets = append(targets, fileTarget{
+			secret: secret,
+			path:   filepath.Join(s.directory(secret), secret.Credential),
+			mode:   0400,
+			uid:    -1,
+			gid:    -1,
+		})
+	}
+	return targets
+}
+
+// directory returns the directory secret's credential file is written to.
+func (s *CredStore) directory(secret Secret) string {
+	if secret.Encrypt != "" {
+		if s.EncryptedDirectory != "" {
+			return s.EncryptedDirectory
+		}
+		return filepath.Join(runtimeDir(), "credstore.encrypted")
+	}
+	if s.Directory != "" {
+		return s.Directory
+	}
+	return filepath.Join(runtimeDir(), "credstore")
+}
@@ -0,0 +1,5 @@
+package main
+
+type DatabaseSecret struct {
+	Role string `yaml:"role"` // The database role to generate credentials for
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/pkg/errors"
+)
+
+const (
+	dbusName      = "org.murf.CredentialsVault1"
+	dbusPath      = dbus.ObjectPath("/org/murf/CredentialsVault1")
+	dbusInterface = "org.murf.CredentialsVault1.Manager"
+)
+
+// dbusManager is the daemon's D-Bus interface, through which operators and
+// other tooling can inspect and control it without signals. Only the users
+// allowed to use the control socket may call it.
+type dbusManager struct {
+	app  *App
+	ctx  context.Context
+	conn *dbus.Conn
+}
+
+// authorize checks that the caller sender runs as a user allowed to control
+// the daemon, as the bus reports.
+func (m *dbusManager) authorize(sender dbus.Sender) *dbus.Error {
+	var uid uint32
+	err := m.conn.BusObject().Call("org.freedesktop.DBus.GetConnectionUnixUser", 0, string(sender)).Store(&uid)
+	if err == nil {
+		err = m.app.authorizeControlUID(uid)
+	}
+	if err != nil {
+		logWarning("Refusing D-Bus call from %s: %+v", sender, err)
+		return dbus.NewError("org.freedesktop.DBus.Error.AccessDenied", []interface{}{err.Error()})
+	}
+	return nil
+}
+
+// servedSecret describes a secret being served, as listed over D-Bus.
+type servedSecret struct {
+	Socket string
+	Path   string
+}
+
+// Reload re-reads the configuration and fetches every secret afresh.
+func (m *dbusManager) Reload(sender dbus.Sender) *dbus.Error {
+	if err := m.authorize(sender); err != nil {
+		return err
+	}
+	log.Print("Reload requested over D-Bus")
+	m.app.reloadConfig(m.ctx)
+	return nil
+}
+
+// ListSecrets returns the socket and Vault path of each secret being served.
+func (m *dbusManager) ListSecrets(sender dbus.Sender) ([]servedSecret, *dbus.Error) {
+	if err := m.authorize(sender); err != nil {
+		return nil, err
+	}
+	secrets := append(m.app.servedSecrets(), m.app.discoveredSecrets()...)
+	served := make([]servedSecret, 0, len(secrets))
+	for _, secret := range secrets {
+		served = append(served, servedSecret{Socket: m.app.socketAddress(secret), Path: m.app.readPath(secret)})
+	}
+	return served, nil
+}
+
+// FlushCache forgets cached secrets, so they are fetched when next served.
+func (m *dbusManager) FlushCache(sender dbus.Sender) *dbus.Error {
+	if err := m.authorize(sender); err != nil {
+		return err
+	}
+	m.app.flushCaches()
+	log.Print("Flushed cached secrets")
+	return nil
+}
+
+// GetStatus reports the daemon's health, as checked for the watchdog, and
+// what it is serving.
+func (m *dbusManager) GetStatus(sender dbus.Sender) (map[string]dbus.Variant, *dbus.Error) {
+	if err := m.authorize(sender); err != nil {
+		return nil, err
+	}
+	status := map[string]dbus.Variant{
+		"Healthy": dbus.MakeVariant(true),
+		"Secrets": dbus.MakeVariant(uint32(len(m.app.servedSecrets()) + len(m.app.discoveredSecrets()))),
+	}
+	if err := m.app.checkHealth(m.ctx); err != nil {
+		status["Healthy"] = dbus.MakeVariant(false)
+		status["Error"] = dbus.MakeVariant(err.Error())
+	}
+
+	m.app.leases.Lock()
+	status["Leases"] = dbus.MakeVariant(uint32(len(m.app.leases.leases)))
+	m.app.leases.Unlock()
+
+	return status, nil
+}
+
+// serveDBus exports the daemon's D-Bus interface on the system or session
+// bus, until ctx is cancelled.
+func (app *App) serveDBus(ctx context.Context, bus string) error {
+	var conn *dbus.Conn
+	var err error
+	switch bus {
+	case "system":
+		conn, err = dbus.ConnectSystemBus()
+	case "session":
+		conn, err = dbus.ConnectSessionBus()
+	default:
+		return errors.Errorf("unsupported dbus bus %q", bus)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "connecting to the %s bus", bus)
+	}
+
+	manager := &dbusManager{app: app, ctx: ctx, conn: conn}
+	if err = conn.Export(manager, dbusPath, dbusInterface); err != nil {
+		conn.Close()
+		return errors.Wrap(err, "exporting D-Bus interface")
+	}
+	node := &introspect.Node{
+		Name: string(dbusPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{Name: dbusInterface, Methods: introspect.Methods(manager)},
+		},
+	}
+	if err = conn.Export(introspect.NewIntrospectable(node), dbusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		conn.Close()
+		return errors.Wrap(err, "exporting D-Bus introspection")
+	}
+
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return errors.Wrapf(err, "requesting D-Bus name %s", dbusName)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return errors.Errorf("D-Bus name %s is already taken", dbusName)
+	}
+	log.Printf("Serving D-Bus interface as %s on the %s bus", dbusName, bus)
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	return nil
+}
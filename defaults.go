@@ -0,0 +1,99 @@
+package main
+
+import "reflect"
+
+// applyDefaults gives every secret configured the settings from defaults
+// which it leaves unset.
+func (config *Config) applyDefaults() {
+	if config.Defaults == nil {
+		return
+	}
+	config.eachSecret(func(secret *Secret) {
+		*secret = withDefaults(*secret, *config.Defaults)
+	})
+}
+
+// eachSecret calls fn with each secret configured, in any of the places
+// secrets are, so that it can change them.
+func (config *Config) eachSecret(fn func(*Secret)) {
+	for i := range config.Secrets {
+		fn(&config.Secrets[i])
+	}
+	for i := range config.Files {
+		fn(&config.Files[i])
+	}
+	for i := range config.Discover {
+		fn(&config.Discover[i].Secret)
+	}
+	if cs := config.CredentialSocket; cs != nil {
+		for name, secret := range cs.Credentials {
+			fn(&secret)
+			cs.Credentials[name] = secret
+		}
+		for i := range cs.Rules {
+			fn(&cs.Rules[i].Secret)
+		}
+	}
+	if s := config.CredStore; s != nil {
+		for name, secret := range s.Credentials {
+			fn(&secret)
+			s.Credentials[name] = secret
+		}
+	}
+}
+
+// defaulted returns secrets with the settings from defaults which they
+// leave unset.
+func (config *Config) defaulted(secrets []Secret) []Secret {
+	if config.Defaults == nil {
+		return secrets
+	}
+	for i := range secrets {
+		secrets[i] = withDefaults(secrets[i], *config.Defaults)
+	}
+	return secrets
+}
+
+// withDefaults returns secret with each setting it leaves unset taken from
+// defaults. Being unset is all that can be told of a setting, so one that is
+// true by default can't be turned off again for a secret. The server a secret
+// is fetched from is taken whole: a secret setting its own vault takes no
+// cluster or identity from defaults, and one setting a cluster or identity
+// takes no vault.
+func withDefaults(secret Secret, defaults Secret) Secret {
+	if secret.Vault != nil {
+		defaults.Cluster, defaults.Identity = "", ""
+	}
+	if secret.Cluster != "" || secret.Identity != "" {
+		defaults.Vault = nil
+	}
+
+	into, from := reflect.ValueOf(&secret).Elem(), reflect.ValueOf(defaults)
+	for i := 0; i < into.NumField(); i++ {
+		field := into.Field(i)
+		if field.CanSet() && field.IsZero() {
+			field.Set(from.Field(i))
+		}
+	}
+	return secret
+}
+
+// undefaultable are the settings identifying a secret, which make no sense
+// shared by every secret.
+var undefaultable = []string{"socket_path", "vault_path", "vsock_port", "fd_name", "aliases", "credential", "file_path"}
+
+// defaultsErrors returns the problems with the settings in defaults.
+func defaultsErrors(name string, defaults *Secret) []string {
+	if defaults == nil {
+		return nil
+	}
+
+	var problems []string
+	value := reflect.ValueOf(*defaults)
+	for _, key := range undefaultable {
+		if field, ok := yamlField(value.Type(), key); ok && !value.FieldByIndex(field.Index).IsZero() {
+			problems = append(problems, name+" defaults: "+key+" can't have a default")
+		}
+	}
+	return append(problems, vaultErrors(name+" defaults", *defaults)...)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithDefaults(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name     string
+		secret   Secret
+		defaults Secret
+		want     Secret
+	}{
+		{
+			name:     "unset settings defaulted",
+			secret:   Secret{SocketPath: "db.sock", VaultPath: "app/db"},
+			defaults: Secret{Mount: "kv", Field: "password", AllowedUnits: []string{"app.service"}},
+			want:     Secret{SocketPath: "db.sock", VaultPath: "app/db", Mount: "kv", Field: "password", AllowedUnits: []string{"app.service"}},
+		},
+		{
+			name:     "set settings kept",
+			secret:   Secret{SocketPath: "db.sock", Mount: "secret", AllowedUnits: []string{"db.service"}},
+			defaults: Secret{Mount: "kv", AllowedUnits: []string{"app.service"}},
+			want:     Secret{SocketPath: "db.sock", Mount: "secret", AllowedUnits: []string{"db.service"}},
+		},
+		{
+			name:     "optional booleans kept when false",
+			secret:   Secret{SocketPath: "db.sock", Required: &no},
+			defaults: Secret{Required: &yes},
+			want:     Secret{SocketPath: "db.sock", Required: &no},
+		},
+		{
+			name:     "booleans defaulted when false",
+			secret:   Secret{SocketPath: "db.sock", PID1Only: false},
+			defaults: Secret{PID1Only: true},
+			want:     Secret{SocketPath: "db.sock", PID1Only: true},
+		},
+		{
+			name:     "own vault takes no cluster or identity",
+			secret:   Secret{SocketPath: "db.sock", Vault: &Cluster{Address: "https://isolated"}},
+			defaults: Secret{Cluster: "dr", Identity: "reader", Mount: "kv"},
+			want:     Secret{SocketPath: "db.sock", Vault: &Cluster{Address: "https://isolated"}, Mount: "kv"},
+		},
+		{
+			name:     "own cluster takes no vault",
+			secret:   Secret{SocketPath: "db.sock", Cluster: "dr"},
+			defaults: Secret{Vault: &Cluster{Address: "https://isolated"}, Mount: "kv"},
+			want:     Secret{SocketPath: "db.sock", Cluster: "dr", Mount: "kv"},
+		},
+		{
+			name:     "own identity takes no vault",
+			secret:   Secret{SocketPath: "db.sock", Identity: "reader"},
+			defaults: Secret{Vault: &Cluster{Address: "https://isolated"}},
+			want:     Secret{SocketPath: "db.sock", Identity: "reader"},
+		},
+		{
+			name:     "no defaults",
+			secret:   Secret{SocketPath: "db.sock", Field: "password"},
+			defaults: Secret{},
+			want:     Secret{SocketPath: "db.sock", Field: "password"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withDefaults(tt.secret, tt.defaults); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withDefaults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	config := Config{
+		Defaults: &Secret{Mount: "kv"},
+		Secrets:  []Secret{{SocketPath: "a.sock"}, {SocketPath: "b.sock", Mount: "secret"}},
+		Files:    []Secret{{FilePath: "/etc/app/key"}},
+		Discover: []Discovery{{}},
+		CredentialSocket: &CredentialSocket{
+			Credentials: map[string]Secret{"db": {VaultPath: "app/db"}},
+			Rules:       []CredentialRule{{Credential: "db_{name}"}},
+		},
+	}
+	config.applyDefaults()
+
+	var mounts []string
+	config.eachSecret(func(secret *Secret) {
+		mounts = append(mounts, secret.Mount)
+	})
+	want := []string{"kv", "secret", "kv", "kv", "kv", "kv"}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("applyDefaults() gave mounts %v, want %v", mounts, want)
+	}
+}
+
+func TestDefaultsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults *Secret
+		want     []string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:     "defaultable",
+			defaults: &Secret{Mount: "kv", Field: "password", Mode: "0400"},
+		},
+		{
+			name:     "identifying settings",
+			defaults: &Secret{SocketPath: "db.sock", VaultPath: "app/db", Aliases: []string{"x.sock"}},
+			want: []string{
+				"config.yml defaults: socket_path can't have a default",
+				"config.yml defaults: vault_path can't have a default",
+				"config.yml defaults: aliases can't have a default",
+			},
+		},
+		{
+			name:     "vault with cluster",
+			defaults: &Secret{Vault: &Cluster{Address: "https://isolated"}, Cluster: "dr"},
+			want:     []string{"config.yml defaults: vault can't be set along with cluster or identity"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := defaultsErrors("config.yml", tt.defaults); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("defaultsErrors() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
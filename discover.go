@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Discovery struct {
+	Prefix     string        `yaml:"prefix"`      // The KV path whose keys are each served on a socket
+	SocketName string        `yaml:"socket_name"` // A text/template for each socket's path relative to socket_root, given .Key and .Path (default: {{.Key}})
+	Interval   time.Duration `yaml:"interval"`    // How often the prefix is listed again (default: 5m)
+	Limit      int           `yaml:"limit"`       // The most keys served from the prefix (default: 100)
+
+	Secret Secret `yaml:"secret"` // Settings for the discovered secrets, e.g. mount, field or format
+}
+
+const (
+	defaultDiscoveryInterval = 5 * time.Minute
+	defaultDiscoveryLimit    = 100
+)
+
+// discoveredSockets holds the secrets being served from discovery, and how to
+// stop serving each, by socket path.
+type discoveredSockets struct {
+	sync.Mutex
+	secrets map[string]Secret
+	stop    map[string]context.CancelFunc
+}
+
+// secretFor returns the secret a discovered key is served as.
+func (d *Discovery) secretFor(key string) (Secret, error) {
+	secret := d.Secret
+	secret.Type = "kv"
+	secret.VaultPath = strings.Trim(d.Prefix, "/") + "/" + key
+
+	name := d.SocketName
+	if name == "" {
+		name = "{{.Key}}"
+	}
+	tmpl, err := template.New("socket_name").Parse(name)
+	if err != nil {
+		return secret, errors.Wrapf(err, "parsing socket_name for %s", d.Prefix)
+	}
+	var b bytes.Buffer
+	if err = tmpl.Execute(&b, map[string]string{"Key": key, "Path": secret.VaultPath}); err != nil {
+		return secret, errors.Wrapf(err, "executing socket_name for %s", secret.VaultPath)
+	}
+	secret.SocketPath = b.String()
+	return secret, nil
+}
+
+// discoveryErrors returns the problems with the socket a discovered key would
+// be served on. Its path comes from the key's name, so mustn't leave
+// socket_root or take over the socket of a secret configured locally.
+func (config *Config) discoveryErrors(secret Secret) []string {
+	problems := socketPathErrors(secret.VaultPath, secret)
+	if by, ok := config.localAddresses()[secret.SocketPath]; ok {
+		problems = append(problems, secret.VaultPath+": "+secret.SocketPath+" is already served by "+by)
+	}
+	return problems
+}
+
+// discover serves each key beneath a discovery's prefix on its own socket,
+// listing the prefix again periodically to pick up new keys and stop serving
+// removed ones, until ctx is cancelled. Only the keys directly beneath the
+// prefix are served, up to its limit.
+func (app *App) discover(ctx context.Context, d *Discovery) {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = defaultDiscoveryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := app.rescan(ctx, d); err != nil {
+			logError("Error discovering secrets beneath %s: %+v", d.Prefix, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rescan lists a discovery's prefix, reconciling the sockets served with the keys found.
+func (app *App) rescan(ctx context.Context, d *Discovery) error {
+	keys, err := app.listKV(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	limit := d.Limit
+	if limit <= 0 {
+		limit = defaultDiscoveryLimit
+	}
+	if len(keys) > limit {
+		logWarning("Found %d secrets beneath %s, serving only the first %d", len(keys), d.Prefix, limit)
+		keys = keys[:limit]
+	}
+
+	found := map[string]Secret{}
+	for _, key := range keys {
+		secret, err := d.secretFor(key)
+		if err != nil {
+			return err
+		}
+		if problems := app.config.discoveryErrors(secret); len(problems) > 0 {
+			logWarning("Not serving %s: %s", secret.VaultPath, strings.Join(problems, "; "))
+			continue
+		}
+		found[secret.SocketPath] = secret
+	}
+
+	app.discovered.Lock()
+	defer app.discovered.Unlock()
+
+	for socketPath, secret := range app.discovered.secrets {
+		if _, ok := found[socketPath]; ok || !strings.HasPrefix(secret.VaultPath, strings.Trim(d.Prefix, "/")+"/") {
+			continue
+		}
+		log.Printf("Secret %s was removed, no longer serving it", secret.VaultPath)
+		app.discovered.stop[socketPath]()
+		if app.config.FDStore {
+			unstoreSocket(secret)
+		}
+		delete(app.discovered.secrets, socketPath)
+		delete(app.discovered.stop, socketPath)
+	}
+
+	for socketPath, secret := range found {
+		if _, ok := app.discovered.secrets[socketPath]; ok {
+			continue
+		}
+		if app.discovered.secrets == nil {
+			app.discovered.secrets = map[string]Secret{}
+			app.discovered.stop = map[string]context.CancelFunc{}
+		}
+		ln, err := app.listenSocket(secret)
+		if err != nil {
+			logError("%v", err)
+			continue
+		}
+		serveCtx, stop := context.WithCancel(ctx)
+		app.discovered.secrets[socketPath] = secret
+		app.discovered.stop[socketPath] = stop
+		go app.serveHealing(serveCtx, secret, ln, func(ctx context.Context, ln net.Listener) {
+			app.socketSecretListen(ctx, secret, ln)
+		})
+	}
+
+	return nil
+}
+
+// listKV returns the secrets directly beneath a discovery's prefix, sorted.
+func (app *App) listKV(ctx context.Context, d *Discovery) ([]string, error) {
+	secret := d.Secret
+	secret.Type = "kv"
+	mount := app.mountFor(secret)
+	prefix := strings.Trim(d.Prefix, "/")
+
+	path := mount + "/metadata/" + prefix
+	if app.kvVersion(secret) == 1 {
+		path = mount + "/" + prefix
+	}
+
+	s, err := app.clientFor(secret).Logical().ListWithContext(ctx, path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing %s", path)
+	}
+	if s == nil {
+		return nil, nil
+	}
+
+	listed, _ := s.Data["keys"].([]interface{})
+	keys := make([]string, 0, len(listed))
+	for _, k := range listed {
+		if key, ok := k.(string); ok && !strings.HasSuffix(key, "/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// discoveredSecrets returns the secrets currently served from discovery.
+func (app *App) discoveredSecrets() []Secret {
+	app.discovered.Lock()
+	defer app.discovered.Unlock()
+
+	secrets := make([]Secret, 0, len(app.discovered.secrets))
+	for _, secret := range app.discovered.secrets {
+		secrets = append(secrets, secret)
+	}
+	return secrets
+}
+
+// removeSocket removes the socket of a secret no longer being served.
+func (app *App) removeSocket(secret Secret) {
+	if _, ok := app.activatedListener(secret); ok {
+		// The socket belongs to systemd
+		return
+	}
+	if !secret.onFilesystem() {
+		// Abstract and vsock sockets go away when closed
+		return
+	}
+	sockPath := app.config.SocketRoot + secret.SocketPath
+	if err := os.Remove(sockPath); os.IsNotExist(err) {
+		// Already gone, as once a serve_once secret is served
+	} else if err != nil {
+		logError("%v", err)
+	} else {
+		log.Printf("Removed socket %s", sockPath)
+	}
+}
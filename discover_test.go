@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiscoveryErrors(t *testing.T) {
+	config := &Config{
+		Secrets:          []Secret{{SocketPath: "db.sock", VaultPath: "app/db"}},
+		CredentialSocket: &CredentialSocket{SocketPath: "credentials.sock"},
+	}
+	d := &Discovery{Prefix: "discovered/", SocketName: "{{.Key}}"}
+
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{key: "api.sock"},
+		{key: "db.sock", want: []string{"discovered/db.sock: db.sock is already served by a local secret"}},
+		{key: "credentials.sock", want: []string{"discovered/credentials.sock: credentials.sock is already served by credential_socket"}},
+		{key: "..", want: []string{"discovered/..: socket path .. must be a clean path within socket_root"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			secret, err := d.secretFor(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.discoveryErrors(secret); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("discoveryErrors() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
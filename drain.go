@@ -0,0 +1,32 @@
+package main
+
+import (
+	"time"
+)
+
+// defaultDrainTimeout bounds how long shutdown waits for the connections
+// being served when drain_timeout isn't set.
+const defaultDrainTimeout = 10 * time.Second
+
+// drain waits for the connections being served to finish, refusing any more
+// that are accepted meanwhile. Any still being served after the drain timeout are
+// abandoned, cancelling their requests to Vault.
+func (app *App) drain() {
+	timeout := app.config.DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+
+	done := make(chan struct{})
+	go func() {
+		app.inflight.wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logWarning("Abandoning connections still being served after %s", timeout)
+	}
+	app.abandonConns()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// dropInName is the name of the drop-in files generated for consumer units.
+const dropInName = "50-vault-credentials.conf"
+
+// runDropIns implements the dropins subcommand, which prints a systemd
+// drop-in for each unit consuming secrets, loading them as credentials from
+// their sockets, or installs them with -install.
+func runDropIns(config *Config, args []string) error {
+	flags := flag.NewFlagSet("dropins", flag.ExitOnError)
+	install := flags.Bool("install", false, "Install the drop-ins rather than printing them.")
+	unitDir := flags.String("unit-dir", defaultUnitDir(), "The directory to install drop-ins in.")
+	flags.Parse(args)
+
+	dropIns, err := consumerDropIns(config)
+	if err != nil {
+		return err
+	}
+
+	units := make([]string, 0, len(dropIns))
+	for unit := range dropIns {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+
+	for _, unit := range units {
+		path := filepath.Join(*unitDir, unit+".d", dropInName)
+		if !*install {
+			fmt.Printf("# %s\n%s\n", path, dropIns[unit])
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return errors.Wrap(err, "creating drop-in directory")
+		}
+		if err = ioutil.WriteFile(path, []byte(dropIns[unit]), 0644); err != nil {
+			return errors.Wrap(err, "writing drop-in")
+		}
+		log.Printf("Installed %s", path)
+	}
+	if *install && len(units) > 0 {
+		if userMode() {
+			log.Print("Run systemctl --user daemon-reload for the drop-ins to take effect")
+		} else {
+			log.Print("Run systemctl daemon-reload for the drop-ins to take effect")
+		}
+	}
+	return nil
+}
+
+// defaultUnitDir returns the directory drop-ins are installed in by default:
+// that of the system, or in user mode the user's.
+func defaultUnitDir() string {
+	if !userMode() {
+		return "/etc/systemd/system"
+	}
+	config := os.Getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		home, _ := os.UserHomeDir()
+		config = filepath.Join(home, ".config")
+	}
+	return filepath.Join(config, "systemd", "user")
+}
+
+// consumerDropIns returns the content of the drop-in for each unit consuming
+// secrets: those listed in their units, or else their allowed_units.
+func consumerDropIns(config *Config) (map[string]string, error) {
+	root, err := filepath.Abs(config.SocketRoot)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving socket_root")
+	}
+
+	credentials := map[string][]string{}
+	add := func(secret Secret, name, path string) {
+		units := secret.Units
+		if len(units) == 0 {
+			units = secret.AllowedUnits
+		}
+		directive := "LoadCredential="
+		if secret.Encrypt != "" {
+			directive = "LoadCredentialEncrypted="
+		}
+		for _, unit := range units {
+			credentials[unit] = append(credentials[unit], directive+name+":"+path)
+		}
+	}
+
+	for _, secret := range config.Secrets {
+		if secret.metadataOnly {
+			continue
+		}
+		if !secret.onFilesystem() {
+			logWarning("Skipping %s: credentials can only be loaded from sockets on the filesystem", secret.VaultPath)
+			continue
+		}
+		add(secret, secret.credentialName(), filepath.Join(root, secret.SocketPath))
+	}
+
+	if cs := config.CredentialSocket; cs != nil {
+		names := make([]string, 0, len(cs.Credentials))
+		for name := range cs.Credentials {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(cs.Credentials[name], name, filepath.Join(root, cs.SocketPath))
+		}
+	}
+
+	dropIns := make(map[string]string, len(credentials))
+	for unit, lines := range credentials {
+		var b strings.Builder
+		writeDropIn(&b, lines)
+		dropIns[unit] = b.String()
+	}
+	return dropIns, nil
+}
+
+func writeDropIn(w io.Writer, lines []string) {
+	fmt.Fprintln(w, "# Generated by systemd-credentials-vault")
+	fmt.Fprintln(w, "[Service]")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// dynamicSecret returns the credentials currently leased for secret, reading
+// new ones from path, with query as request parameters, when there are none
+// or their lease is running out. render turns the response into the
+// credentials served.
+func (app *App) dynamicSecret(ctx context.Context, secret Secret, path string, query url.Values, render func(map[string]interface{}) (*secretValue, error)) (*secretValue, error) {
+	defer app.leases.issuing.lock(secret.SocketPath)()
+
+	app.leases.Lock()
+	value, ok := app.leases.current(secret.SocketPath)
+	app.leases.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	client := app.clientFor(secret)
+	s, err := client.Logical().ReadWithDataWithContext(ctx, path, requestQuery(secret, query))
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading credentials from %s", path)
+	}
+	if s == nil {
+		return nil, errors.Errorf("no credentials returned from %s", path)
+	}
+
+	value, err = render(s.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.LeaseID == "" {
+		return value, nil
+	}
+
+	app.leases.Lock()
+	app.leases.track(secret.SocketPath, client, path, s, value)
+	app.leases.Unlock()
+	log.Printf("Issued credentials from %s with lease %s for %ds", path, s.LeaseID, s.LeaseDuration)
+
+	return value, nil
+}
+
+// tokenCredentials returns a function rendering the token in field bare by
+// default, and as the environment variable env in env format.
+func tokenCredentials(field, env string) func(map[string]interface{}) (*secretValue, error) {
+	return func(data map[string]interface{}) (*secretValue, error) {
+		token, ok := data[field].(string)
+		if !ok {
+			return nil, errors.Errorf("no %s returned", field)
+		}
+		return &secretValue{
+			Data:    data,
+			Default: []byte(token),
+			Formats: map[string][]byte{"env": []byte(env + "=" + token + "\n")},
+		}, nil
+	}
+}
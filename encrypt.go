@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// encryptCredential encrypts content in systemd's encrypted credential
+// format using systemd-creds, for units to load with LoadCredentialEncrypted=
+// or ImportCredential=. The credential name is embedded, and checked by
+// systemd when decrypting.
+func encryptCredential(ctx context.Context, secret Secret, content []byte) ([]byte, error) {
+	name := secret.credentialName()
+	cmd := exec.CommandContext(ctx, "systemd-creds", "encrypt", "--name="+name, "--with-key="+secret.Encrypt, "-", "-")
+	cmd.Stdin = bytes.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	encrypted, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "encrypting credential %s: %s", name, strings.TrimSpace(stderr.String()))
+	}
+	return encrypted, nil
+}
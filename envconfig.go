@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-yaml/yaml"
+)
+
+// envPrefix begins the environment variables a configuration can be given
+// in, for containers and the like where a file is awkward to provide.
+const envPrefix = "SCV_"
+
+// envAliases are shorter names for settings of secrets in environment
+// variables, e.g. SCV_SECRET_0_PATH.
+var envAliases = map[string]string{
+	"PATH":   "vault_path",
+	"SOCKET": "socket_path",
+}
+
+// envConfigured reports whether any configuration is given in environment
+// variables.
+func envConfigured() bool {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, envPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadEnvConfig reads the configuration given in environment variables,
+// named for the settings in the configuration file: SCV_VAULT_MOUNT sets
+// vault_mount, SCV_AUTH_METHOD sets method under auth, and
+// SCV_SECRET_0_VAULT_PATH sets vault_path of the first secret. Lists of
+// values are separated by commas.
+func loadEnvConfig(checks *configChecks) (*Config, error) {
+	var names []string
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, envPrefix) {
+			names = append(names, strings.SplitN(env, "=", 2)[0])
+		}
+	}
+	sort.Strings(names)
+
+	config := &Config{}
+	settings := map[string]interface{}{}
+	var problems configErrors
+	for _, name := range names {
+		if err := setEnv(settings, reflect.TypeOf(config).Elem(), strings.TrimPrefix(name, envPrefix), os.Getenv(name)); err != "" {
+			problems = append(problems, name+": "+err)
+		}
+	}
+	if len(problems) > 0 {
+		return config, problems
+	}
+
+	content, err := yaml.Marshal(envLists(settings))
+	if err != nil {
+		return nil, err
+	}
+	return config, config.validate("environment", content, false, yaml.UnmarshalStrict(content, config), checks)
+}
+
+// setEnv records value as the setting of t named by key, an environment
+// variable without its prefix, returning what's wrong with it if it can't.
+// Entries of lists are recorded by their index until envLists orders them.
+func setEnv(settings map[string]interface{}, t reflect.Type, key, value string) string {
+	if t == reflect.TypeOf(Secret{}) {
+		if alias, ok := envAliases[key]; ok {
+			key = strings.ToUpper(alias)
+		}
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.PkgPath != "" || name == "" || name == "-" {
+			continue
+		}
+		upper := strings.ToUpper(name)
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		switch {
+		case key == upper && envScalar(ft):
+			converted, err := envSetting(ft, value)
+			if err != "" {
+				return err
+			}
+			settings[name] = converted
+			return ""
+
+		case ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}) && strings.HasPrefix(key, upper+"_"):
+			block, _ := settings[name].(map[string]interface{})
+			if block == nil {
+				block = map[string]interface{}{}
+				settings[name] = block
+			}
+			return setEnv(block, ft, strings.TrimPrefix(key, upper+"_"), value)
+
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			// Lists of blocks are named in the singular as well, e.g. SCV_SECRET_0_...
+			for _, prefix := range []string{upper + "_", strings.TrimSuffix(upper, "S") + "_"} {
+				if !strings.HasPrefix(key, prefix) {
+					continue
+				}
+				parts := strings.SplitN(strings.TrimPrefix(key, prefix), "_", 2)
+				index, err := strconv.Atoi(parts[0])
+				if err != nil || index < 0 || len(parts) < 2 {
+					return "expected " + envPrefix + prefix + "<index>_<setting>"
+				}
+				entries, _ := settings[name].(map[int]map[string]interface{})
+				if entries == nil {
+					entries = map[int]map[string]interface{}{}
+					settings[name] = entries
+				}
+				if entries[index] == nil {
+					entries[index] = map[string]interface{}{}
+				}
+				return setEnv(entries[index], ft.Elem(), parts[1], value)
+			}
+		}
+	}
+	return "no such setting"
+}
+
+// envScalar reports whether a setting of type t can be given in one
+// environment variable.
+func envScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return envScalar(t.Elem())
+	}
+	return false
+}
+
+// envSetting converts value to the type t of the setting it is given for, so
+// that it reads as that type in YAML.
+func envSetting(t reflect.Type, value string) (interface{}, string) {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return value, ""
+	}
+
+	var converted interface{}
+	var err error
+	switch t.Kind() {
+	case reflect.String:
+		converted = value
+	case reflect.Bool:
+		converted, err = strconv.ParseBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		converted, err = strconv.ParseInt(value, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		converted, err = strconv.ParseUint(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		converted, err = strconv.ParseFloat(value, 64)
+	case reflect.Slice:
+		var list []interface{}
+		for _, item := range strings.Split(value, ",") {
+			v, problem := envSetting(t.Elem(), strings.TrimSpace(item))
+			if problem != "" {
+				return nil, problem
+			}
+			list = append(list, v)
+		}
+		converted = list
+	}
+	if err != nil {
+		return nil, "expected a " + t.Kind().String()
+	}
+	return converted, ""
+}
+
+// envLists turns the entries of lists recorded by setEnv into lists, in the
+// order of their indices.
+func envLists(settings map[string]interface{}) map[string]interface{} {
+	for name, setting := range settings {
+		switch setting := setting.(type) {
+		case map[string]interface{}:
+			envLists(setting)
+		case map[int]map[string]interface{}:
+			var indices []int
+			for index := range setting {
+				indices = append(indices, index)
+			}
+			sort.Ints(indices)
+			list := make([]interface{}, 0, len(indices))
+			for _, index := range indices {
+				list = append(list, envLists(setting[index]))
+			}
+			settings[name] = list
+		}
+	}
+	return settings
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadEnvConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    Config
+		wantErr string
+	}{
+		{
+			name: "settings",
+			env:  map[string]string{"SCV_VAULT_MOUNT": "kv", "SCV_KV_VERSION": "2", "SCV_OPENBAO": "true"},
+			want: Config{VaultMount: "kv", KVVersion: 2, OpenBao: true},
+		},
+		{
+			name: "blocks",
+			env: map[string]string{
+				"SCV_VAULT_MOUNT":               "kv",
+				"SCV_AUTH_METHOD":               "approle",
+				"SCV_AUTH_APPROLE_ROLE_ID_FILE": "/etc/role_id",
+			},
+			want: Config{VaultMount: "kv", Auth: &AuthConfig{Method: "approle", AppRole: &AppRoleAuth{RoleIDFile: "/etc/role_id"}}},
+		},
+		{
+			name: "lists of blocks in index order",
+			env: map[string]string{
+				"SCV_VAULT_MOUNT":         "kv",
+				"SCV_SECRET_10_PATH":      "app/b",
+				"SCV_SECRET_10_SOCKET":    "b.sock",
+				"SCV_SECRETS_2_PATH":      "app/a",
+				"SCV_SECRET_2_SOCKET":     "a.sock",
+				"SCV_SECRET_2_KV_VERSION": "1",
+			},
+			want: Config{VaultMount: "kv", Secrets: []Secret{
+				{VaultPath: "app/a", SocketPath: "a.sock", KVVersion: 1},
+				{VaultPath: "app/b", SocketPath: "b.sock"},
+			}},
+		},
+		{
+			name: "lists of values",
+			env: map[string]string{
+				"SCV_VAULT_MOUNT":            "kv",
+				"SCV_SECRET_0_PATH":          "app/a",
+				"SCV_SECRET_0_SOCKET":        "a.sock",
+				"SCV_SECRET_0_RESTART_UNITS": "a.service, b.service",
+			},
+			want: Config{VaultMount: "kv", Secrets: []Secret{
+				{VaultPath: "app/a", SocketPath: "a.sock", RestartUnits: []string{"a.service", "b.service"}},
+			}},
+		},
+		{
+			name:    "unknown setting",
+			env:     map[string]string{"SCV_VAULT_MOUNTS": "kv"},
+			wantErr: "SCV_VAULT_MOUNTS: no such setting",
+		},
+		{
+			name:    "wrong type",
+			env:     map[string]string{"SCV_KV_VERSION": "two"},
+			wantErr: "SCV_KV_VERSION: expected a int",
+		},
+		{
+			name:    "no index",
+			env:     map[string]string{"SCV_SECRET_PATH": "app/a"},
+			wantErr: "SCV_SECRET_PATH: expected SCV_SECRET_<index>_<setting>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config, err := loadEnvConfig(newConfigChecks())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadEnvConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadEnvConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(*config, tt.want) {
+				t.Errorf("loadEnvConfig() = %+v, want %+v", *config, tt.want)
+			}
+		})
+	}
+}
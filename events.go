@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// errEventsUnsupported is returned when the Vault server has no event
+// notifications, as before Vault 1.13.
+var errEventsUnsupported = errors.New("Vault server does not support event notifications")
+
+// kvEvent is the part of a Vault event notification identifying the secret written.
+type kvEvent struct {
+	Data struct {
+		EventType string `json:"event_type"`
+		Event     struct {
+			Metadata struct {
+				Path           string `json:"path"`
+				CurrentVersion string `json:"current_version"`
+			} `json:"metadata"`
+		} `json:"event"`
+	} `json:"data"`
+}
+
+// watchEvents subscribes to Vault's KV event notifications until ctx is
+// cancelled, so rotations of served secrets are noticed as they happen rather
+// than when a client next connects. The subscription is made with the default
+// login, and re-established with backoff whenever it drops.
+func (app *App) watchEvents(ctx context.Context) {
+	retry := backoff.NewExponentialBackOff()
+	retry.MaxInterval = maxReloginInterval
+	retry.MaxElapsedTime = 0
+
+	for {
+		err := app.subscribeEvents(ctx, retry)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errEventsUnsupported) {
+			logWarning("Not watching for secret rotations: %+v", err)
+			return
+		}
+
+		wait := retry.NextBackOff()
+		logWarning("Vault event subscription ended, reconnecting in %s: %+v", wait.Round(time.Second), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// subscribeEvents handles KV events from a single subscription, resetting
+// retry once it is established.
+func (app *App) subscribeEvents(ctx context.Context, retry backoff.BackOff) error {
+	conn, err := dialEvents(ctx, app.client, "kv*")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	log.Print("Watching Vault events for secret rotations")
+	retry.Reset()
+
+	for {
+		var event kvEvent
+		if err = conn.ReadJSON(&event); err != nil {
+			return errors.Wrap(err, "reading event")
+		}
+		if !strings.HasSuffix(event.Data.EventType, "write") {
+			continue
+		}
+
+		path := strings.Trim(event.Data.Event.Metadata.Path, "/")
+		secrets := app.servedSecrets()
+		if app.config.CredStore != nil {
+			secrets = append(secrets, app.config.CredStore.secrets()...)
+		}
+		secrets = append(secrets, fileSecrets(app.config.Files)...)
+		for _, secret := range secrets {
+			if (secret.Type == "" || secret.Type == "kv") && secret.Cluster == "" && app.readPath(secret) == path {
+				app.secretRotated(secret, event.Data.Event.Metadata.CurrentVersion)
+			}
+		}
+	}
+}
+
+// dialEvents opens a WebSocket subscription to events of eventType.
+func dialEvents(ctx context.Context, client *api.Client, eventType string) (*websocket.Conn, error) {
+	u, err := url.Parse(client.Address())
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing Vault address")
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path = "/v1/sys/events/subscribe/" + eventType
+	u.RawQuery = url.Values{"json": {"true"}}.Encode()
+
+	header := client.Headers()
+	header.Set("X-Vault-Token", client.Token())
+
+	dialer := *websocket.DefaultDialer
+	if transport, ok := client.CloneConfig().HttpClient.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, errEventsUnsupported
+		}
+		if resp != nil && resp.StatusCode == http.StatusForbidden {
+			return nil, errors.Wrap(err, "subscribing to events requires read and subscribe capabilities on sys/events/subscribe")
+		}
+		return nil, errors.Wrap(err, "subscribing to events")
+	}
+	return conn, nil
+}
+
+// secretRotated is called when a served secret is written in Vault, with its
+// new version if known. Secrets maintained in files are acted on once the file
+// is rewritten.
+func (app *App) secretRotated(secret Secret, version string) {
+	log.Printf("Secret %s was rotated", app.readPath(secret))
+
+	if !app.refreshFiles(secret) {
+		app.onRotation(secret, version)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// runExec implements the exec subcommand, which fetches the configured
+// secrets and runs a command with them, where systemd credentials aren't
+// available. They are given to the command as files in a private directory
+// named by $CREDENTIALS_DIRECTORY, as systemd would, or with -env as
+// environment variables. It returns the command's exit status.
+func runExec(config *Config, args []string) (int, error) {
+	flags := flag.NewFlagSet("exec", flag.ExitOnError)
+	asEnv := flags.Bool("env", false, "Pass secrets as environment variables, named for their credentials, rather than files.")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return 0, errors.New("no command given")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app, credentials, err := fetchCredentials(ctx, config)
+	if app != nil {
+		defer app.revokeTokens(context.Background())
+		if config.RevokeLeasesOnShutdown {
+			defer app.leases.revokeAll(context.Background())
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(flags.Arg(0), flags.Args()[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+
+	if *asEnv {
+		for name, content := range credentials {
+			cmd.Env = append(cmd.Env, envName(name)+"="+string(content))
+		}
+	} else {
+		dir, err := writeCredentialsDir(credentials)
+		if err != nil {
+			return 0, err
+		}
+		defer os.RemoveAll(dir)
+		cmd.Env = append(cmd.Env, "CREDENTIALS_DIRECTORY="+dir)
+	}
+
+	if err = cmd.Start(); err != nil {
+		return 0, errors.Wrapf(err, "starting %s", flags.Arg(0))
+	}
+
+	// Signals are for the command, which decides when we're done
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "running %s", flags.Arg(0))
+	}
+	return 0, nil
+}
+
+// writeCredentialsDir writes credentials to files in a new private directory,
+// on a tmpfs if one is available, returning the directory.
+func writeCredentialsDir(credentials map[string][]byte) (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		base = "/dev/shm"
+	}
+	if _, err := os.Stat(base); err != nil {
+		base = ""
+	}
+
+	dir, err := ioutil.TempDir(base, "vault-credentials.")
+	if err != nil {
+		return "", errors.Wrap(err, "creating credentials directory")
+	}
+	for name, content := range credentials {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), content, 0400); err != nil {
+			os.RemoveAll(dir)
+			return "", errors.Wrapf(err, "writing credential %s", name)
+		}
+	}
+	return dir, nil
+}
+
+// fetchCredentials logs in to Vault and fetches each configured secret once,
+// returning their content by credential name. The app is returned once logged
+// in, for its tokens and leases to be cleaned up, even if fetching fails.
+func fetchCredentials(ctx context.Context, config *Config) (*App, map[string][]byte, error) {
+	app := newApp(config)
+	if err := setupVault(ctx, app); err != nil {
+		return nil, nil, errors.Wrap(err, "configuring Vault client")
+	}
+	if err := app.detectKVVersions(ctx); err != nil {
+		return app, nil, err
+	}
+
+	credentials := map[string][]byte{}
+	for _, secret := range config.Secrets {
+		if secret.Type == "transit" && secret.Transit != nil && secret.Transit.Operation != "" {
+			continue
+		}
+		value, err := app.fetchSecret(ctx, secret)
+		if err != nil {
+			return app, nil, errors.Wrapf(err, "fetching %s", app.readPath(secret))
+		}
+		content, err := renderSecret(secret, value)
+		if err != nil {
+			return app, nil, err
+		}
+		credentials[secret.credentialName()] = content
+	}
+	return app, credentials, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// storeSocket pushes a socket the daemon bound into systemd's file descriptor
+// store, named for secret. When the daemon restarts, systemd passes it back
+// as if by socket activation, so the socket keeps accepting connections while
+// the daemon is upgraded. Requires FileDescriptorStoreMax= on the service.
+func storeSocket(secret Secret, ln net.Listener) {
+	unixListener, ok := ln.(*net.UnixListener)
+	if !ok {
+		return
+	}
+	f, err := unixListener.File()
+	if err != nil {
+		logError("Error storing socket %s with systemd: %+v", secret.SocketPath, err)
+		return
+	}
+	defer f.Close()
+
+	if err = sdNotifyFDs("FDSTORE=1\nFDNAME="+secret.fdName(), f); err != nil {
+		logError("Error storing socket %s with systemd: %+v", secret.SocketPath, err)
+	}
+}
+
+// unstoreSocket removes a secret's socket from systemd's file descriptor store.
+func unstoreSocket(secret Secret) {
+	if err := sdNotifyFDs("FDSTOREREMOVE=1\nFDNAME=" + secret.fdName()); err != nil {
+		logError("Error removing socket %s from systemd: %+v", secret.SocketPath, err)
+	}
+}
+
+// sdNotifyFDs sends state to systemd's notification socket along with files.
+// It does nothing when not run by systemd.
+func sdNotifyFDs(state string, files ...*os.File) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return errors.Wrap(err, "connecting to systemd")
+	}
+	defer conn.Close()
+
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	var rights []byte
+	if len(fds) > 0 {
+		rights = syscall.UnixRights(fds...)
+	}
+
+	_, _, err = conn.WriteMsgUnix([]byte(state), rights, nil)
+	return errors.Wrap(err, "notifying systemd")
+}
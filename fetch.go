@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// secretValue is a secret as read from Vault.
+type secretValue struct {
+	Data    map[string]interface{} // The secret's fields
+	Default []byte                 // The engine's natural rendering, served when neither field nor format are set
+	Formats map[string][]byte      // Engine specific renderings, overriding the generic formats of the same name
+
+	Metadata map[string]interface{} // Version metadata, for KV v2 secrets
+}
+
+// fetchSecret reads secret from Vault using the engine selected by its type.
+func (app *App) fetchSecret(ctx context.Context, secret Secret) (*secretValue, error) {
+	if secret.WrapTTL != "" {
+		return app.wrapSecret(ctx, secret)
+	}
+
+	switch secret.Type {
+	case "", "kv":
+		kv, err := app.fetchKV(ctx, secret)
+		if err != nil {
+			return nil, err
+		}
+		return &secretValue{
+			Data:     kv.Data,
+			Default:  []byte(fmt.Sprintf("%+v", kv)),
+			Metadata: kvMetadata(kv),
+		}, nil
+
+	case "logical", "cubbyhole":
+		path := app.readPath(secret)
+		var s *api.Secret
+		var err error
+		if secret.Write {
+			s, err = app.clientFor(secret).Logical().WriteWithContext(ctx, path, requestData(secret, nil))
+		} else {
+			s, err = app.clientFor(secret).Logical().ReadWithDataWithContext(ctx, path, requestQuery(secret, nil))
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", path)
+		}
+		if s == nil {
+			return nil, errors.Errorf("no secret found at %s", path)
+		}
+		return &secretValue{Data: s.Data, Default: []byte(fmt.Sprintf("%+v", s))}, nil
+
+	case "pki":
+		return app.certificate(ctx, secret)
+
+	case "database":
+		if secret.role() == "" {
+			return nil, errors.New("database secrets require a database role")
+		}
+		return app.dynamicSecret(ctx, secret, app.readPath(secret), nil, envValue)
+
+	case "aws":
+		if secret.role() == "" {
+			return nil, errors.New("aws secrets require an aws role")
+		}
+		return app.dynamicSecret(ctx, secret, app.readPath(secret), awsQuery(secret), awsCredentials)
+
+	case "gcp":
+		if secret.role() == "" {
+			return nil, errors.New("gcp secrets require a gcp roleset")
+		}
+		return app.dynamicSecret(ctx, secret, app.readPath(secret), gcpQuery(secret), gcpCredentials)
+
+	case "azure":
+		if secret.role() == "" {
+			return nil, errors.New("azure secrets require an azure role")
+		}
+		return app.dynamicSecret(ctx, secret, app.readPath(secret), nil, azureCredentials(secret))
+
+	case "consul":
+		if secret.role() == "" {
+			return nil, errors.New("consul secrets require a consul role")
+		}
+		return app.dynamicSecret(ctx, secret, app.readPath(secret), nil, tokenCredentials("token", "CONSUL_HTTP_TOKEN"))
+
+	case "nomad":
+		if secret.role() == "" {
+			return nil, errors.New("nomad secrets require a nomad role")
+		}
+		return app.dynamicSecret(ctx, secret, app.readPath(secret), nil, tokenCredentials("secret_id", "NOMAD_TOKEN"))
+
+	case "rabbitmq":
+		if secret.role() == "" {
+			return nil, errors.New("rabbitmq secrets require a rabbitmq role")
+		}
+		return app.dynamicSecret(ctx, secret, app.readPath(secret), nil, envValue)
+
+	case "ldap":
+		if secret.role() == "" {
+			return nil, errors.New("ldap secrets require an ldap role")
+		}
+		if secret.LDAP.Static {
+			return app.staticCredentials(ctx, secret)
+		}
+		return app.dynamicSecret(ctx, secret, app.readPath(secret), nil, envValue)
+
+	case "transit":
+		if secret.role() == "" {
+			return nil, errors.New("transit secrets require a transit key")
+		}
+		return app.decryptSecret(ctx, secret)
+
+	case "datakey":
+		if secret.role() == "" {
+			return nil, errors.New("datakey secrets require a transit key")
+		}
+		return app.generateDataKey(ctx, secret)
+
+	case "oidc":
+		if secret.role() == "" {
+			return nil, errors.New("oidc secrets require an oidc role")
+		}
+		path := app.readPath(secret)
+		s, err := app.clientFor(secret).Logical().ReadWithDataWithContext(ctx, path, requestQuery(secret, nil))
+		if err != nil {
+			return nil, errors.Wrapf(err, "generating identity token from %s", path)
+		}
+		if s == nil {
+			return nil, errors.Errorf("no identity token returned from %s", path)
+		}
+		token, _ := s.Data["token"].(string)
+		return &secretValue{Data: s.Data, Default: []byte(token)}, nil
+
+	case "ssh":
+		return app.signSSHKey(ctx, secret)
+
+	case "totp":
+		if secret.role() == "" {
+			return nil, errors.New("totp secrets require a totp key")
+		}
+		path := app.readPath(secret)
+		s, err := app.clientFor(secret).Logical().ReadWithDataWithContext(ctx, path, requestQuery(secret, nil))
+		if err != nil {
+			return nil, errors.Wrapf(err, "generating code from %s", path)
+		}
+		if s == nil {
+			return nil, errors.Errorf("no code returned from %s", path)
+		}
+		code, _ := s.Data["code"].(string)
+		return &secretValue{Data: s.Data, Default: []byte(code)}, nil
+
+	default:
+		return nil, errors.Errorf("unsupported secret type %q", secret.Type)
+	}
+}
+
+// readPath returns the API path secret is fetched from.
+func (app *App) readPath(secret Secret) string {
+	switch secret.Type {
+	case "logical":
+		return strings.TrimPrefix(secret.VaultPath, "/")
+	case "cubbyhole":
+		return "cubbyhole/" + strings.Trim(secret.VaultPath, "/")
+	case "pki":
+		return app.mountOr(secret, "pki") + "/issue/" + secret.role()
+	case "database":
+		return app.mountOr(secret, "database") + "/creds/" + secret.role()
+	case "ssh":
+		return app.mountOr(secret, "ssh") + "/sign/" + secret.role()
+	case "aws":
+		endpoint := "creds"
+		if secret.AWS != nil && secret.AWS.Endpoint != "" {
+			endpoint = secret.AWS.Endpoint
+		}
+		return app.mountOr(secret, "aws") + "/" + endpoint + "/" + secret.role()
+	case "gcp":
+		credential := "key"
+		if secret.GCP != nil && secret.GCP.Credential != "" {
+			credential = secret.GCP.Credential
+		}
+		return app.mountOr(secret, "gcp") + "/roleset/" + secret.role() + "/" + credential
+	case "azure":
+		return app.mountOr(secret, "azure") + "/creds/" + secret.role()
+	case "consul":
+		return app.mountOr(secret, "consul") + "/creds/" + secret.role()
+	case "nomad":
+		return app.mountOr(secret, "nomad") + "/creds/" + secret.role()
+	case "rabbitmq":
+		return app.mountOr(secret, "rabbitmq") + "/creds/" + secret.role()
+	case "ldap":
+		if secret.LDAP != nil && secret.LDAP.Static {
+			return app.mountOr(secret, "ldap") + "/static-cred/" + secret.role()
+		}
+		return app.mountOr(secret, "ldap") + "/creds/" + secret.role()
+	case "transit":
+		if secret.Transit != nil && secret.Transit.Operation != "" {
+			return app.transitPath(secret, secret.Transit.Operation)
+		}
+		return app.transitPath(secret, "decrypt")
+	case "datakey":
+		return app.transitPath(secret, "datakey/plaintext")
+	case "oidc":
+		return "identity/oidc/token/" + secret.role()
+	case "totp":
+		return app.mountOr(secret, "totp") + "/code/" + secret.role()
+	default:
+		return app.kvReadPath(secret)
+	}
+}
+
+// fetchCapability returns the capability needed on readPath to fetch secret.
+func fetchCapability(secret Secret) string {
+	if secret.Write {
+		return "update"
+	}
+	switch secret.Type {
+	case "pki", "ssh", "transit", "datakey":
+		return "update"
+	default:
+		return "read"
+	}
+}
+
+// mountOr returns the mount configured for secret, or def if it has none.
+// Unlike mountFor, the global vault_mount is not used, as that is a KV mount.
+func (app *App) mountOr(secret Secret, def string) string {
+	if secret.Mount != "" {
+		return strings.Trim(secret.Mount, "/")
+	}
+	return def
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultRefresh is how often secrets maintained in files are fetched again
+// when no refresh interval is set.
+const defaultRefresh = 5 * time.Minute
+
+// fileTarget is a secret maintained in a file, and how the file is written.
+type fileTarget struct {
+	secret Secret
+	path   string
+	mode   os.FileMode
+	uid    int // -1 to leave unchanged
+	gid    int // -1 to leave unchanged
+}
+
+// fileTargets returns the targets of the secrets configured under files,
+// owned and with the mode configured for each.
+func (app *App) fileTargets() ([]fileTarget, error) {
+	targets := make([]fileTarget, 0, len(app.config.Files))
+	for _, secret := range fileSecrets(app.config.Files) {
+		target := fileTarget{secret: secret, path: secret.FilePath, mode: 0400, uid: -1, gid: -1}
+		var err error
+		if secret.Mode != "" {
+			if target.mode, err = parseMode(secret.Mode); err != nil {
+				return nil, errors.Wrapf(err, "file %s", secret.FilePath)
+			}
+		}
+		if secret.Owner != "" {
+			if target.uid, err = lookupUID(secret.Owner); err != nil {
+				return nil, err
+			}
+		}
+		if secret.Group != "" {
+			if target.gid, err = lookupGID(secret.Group); err != nil {
+				return nil, err
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// fileSecrets returns the secrets configured under files, keyed for caching
+// by their file paths.
+func fileSecrets(files []Secret) []Secret {
+	secrets := make([]Secret, 0, len(files))
+	for _, secret := range files {
+		secret.SocketPath = "file#" + secret.FilePath
+		secrets = append(secrets, secret)
+	}
+	return secrets
+}
+
+// maintainFiles writes each target's secret to its file, and keeps them up to
+// date until ctx is cancelled, fetching them again every refresh or when
+// signalled on rotated. Files are only rewritten when their content changes.
+func (app *App) maintainFiles(ctx context.Context, targets []fileTarget, refresh time.Duration, rotated <-chan struct{}) {
+	if refresh <= 0 {
+		refresh = defaultRefresh
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	written := map[string][32]byte{}
+	for {
+		for _, target := range targets {
+			if err := app.writeTarget(ctx, target, written); err != nil {
+				logError("Error writing %s: %+v", target.path, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-rotated:
+		}
+	}
+}
+
+// writeTarget fetches target's secret and writes it to its file, if it has
+// changed since last written.
+func (app *App) writeTarget(ctx context.Context, target fileTarget, written map[string][32]byte) error {
+	secret := target.secret
+	value, err := app.fetchSecret(ctx, secret)
+	app.fetchResult(secret, err)
+	if err != nil {
+		if isPermissionDenied(err) {
+			app.requestReauth(secret)
+		}
+		return err
+	}
+	content, err := renderSecret(secret, value)
+	if err != nil {
+		return err
+	}
+
+	// Encrypted credentials differ every time, so changes are spotted in
+	// the plain content
+	digest := sha256.Sum256(content)
+	if previous, ok := written[target.path]; ok && previous == digest {
+		return nil
+	}
+
+	if secret.Encrypt != "" {
+		if content, err = encryptCredential(ctx, secret, content); err != nil {
+			return err
+		}
+	}
+
+	if err = writeFileAtomic(target, content); err != nil {
+		return err
+	}
+	_, rewritten := written[target.path]
+	written[target.path] = digest
+	log.Printf("Wrote %s for secret path %s", target.path, app.readPath(secret))
+	if rewritten {
+		var version string
+		if v, ok := value.Metadata["version"]; ok {
+			version = fmt.Sprint(v)
+		}
+		app.onRotation(secret, version)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces target's file with content, by renaming a new file
+// over it, so readers never see a partly written file.
+func writeFileAtomic(target fileTarget, content []byte) error {
+	dir := filepath.Dir(target.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrapf(err, "creating %s", dir)
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(target.path)+".")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(content); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "writing %s", f.Name())
+	}
+	if err = f.Chmod(target.mode); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "changing mode of %s", f.Name())
+	}
+	if target.uid != -1 || target.gid != -1 {
+		if err = f.Chown(target.uid, target.gid); err != nil {
+			f.Close()
+			return errors.Wrapf(err, "changing ownership of %s", f.Name())
+		}
+	}
+	if err = f.Close(); err != nil {
+		return errors.Wrapf(err, "writing %s", f.Name())
+	}
+	if err = os.Rename(f.Name(), target.path); err != nil {
+		return errors.Wrapf(err, "replacing %s", target.path)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-yaml/yaml"
+	"github.com/hashicorp/hcl"
+	"github.com/pkg/errors"
+)
+
+// configYAML converts a configuration written as TOML or HCL, going by the
+// extension of path, to the YAML it is read as, reporting whether it did.
+// JSON needs no converting, being YAML already.
+func configYAML(path string, content []byte) ([]byte, bool, error) {
+	var parsed map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		if err := toml.Unmarshal(content, &parsed); err != nil {
+			return nil, false, errors.Wrap(err, "parsing configuration toml")
+		}
+	case ".hcl":
+		if err := hcl.Unmarshal(content, &parsed); err != nil {
+			return nil, false, errors.Wrap(err, "parsing configuration hcl")
+		}
+		parsed, _ = hclShape(parsed, reflect.TypeOf(Config{})).(map[string]interface{})
+	default:
+		return content, false, nil
+	}
+
+	content, err := yaml.Marshal(parsed)
+	return content, true, err
+}
+
+// hclShape reshapes a value decoded from HCL to suit t. HCL decodes every
+// block as a list of objects, whether t wants a list or a single object, and
+// a single block where a list is wanted as one object.
+func hclShape(v interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object := hclObject(v)
+		if object == nil {
+			return v
+		}
+		shaped := map[string]interface{}{}
+		for key, value := range object {
+			if field, ok := yamlField(t, key); ok {
+				value = hclShape(value, field.Type)
+			}
+			shaped[key] = value
+		}
+		return shaped
+
+	case reflect.Map:
+		object := hclObject(v)
+		if object == nil {
+			return v
+		}
+		shaped := map[string]interface{}{}
+		for key, value := range object {
+			shaped[key] = hclShape(value, t.Elem())
+		}
+		return shaped
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return v
+		}
+		var shaped []interface{}
+		switch list := v.(type) {
+		case []map[string]interface{}:
+			for _, item := range list {
+				shaped = append(shaped, hclShape(item, t.Elem()))
+			}
+		case []interface{}:
+			for _, item := range list {
+				shaped = append(shaped, hclShape(item, t.Elem()))
+			}
+		default:
+			shaped = append(shaped, hclShape(v, t.Elem()))
+		}
+		return shaped
+	}
+	return v
+}
+
+// hclObject returns v as a single object, merging the blocks HCL decodes it
+// as, or nil if it isn't one.
+func hclObject(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v
+	case []map[string]interface{}:
+		object := map[string]interface{}{}
+		for _, block := range v {
+			for key, value := range block {
+				object[key] = value
+			}
+		}
+		return object
+	case []interface{}:
+		object := map[string]interface{}{}
+		for _, item := range v {
+			block, ok := item.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			for key, value := range block {
+				object[key] = value
+			}
+		}
+		return object
+	}
+	return nil
+}
+
+// yamlField returns the field of t read from key in YAML.
+func yamlField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath == "" && strings.Split(field.Tag.Get("yaml"), ",")[0] == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
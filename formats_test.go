@@ -0,0 +1,197 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-yaml/yaml"
+)
+
+func TestConfigYAML(t *testing.T) {
+	want := Config{
+		VaultMount: "kv",
+		Auth:       &AuthConfig{Method: "approle", AppRole: &AppRoleAuth{RoleIDFile: "/etc/role_id"}},
+		Clusters:   map[string]*Cluster{"dr": {Address: "https://dr.example:8200"}},
+		Secrets: []Secret{
+			{VaultPath: "app/db", SocketPath: "db.sock"},
+			{VaultPath: "app/api", SocketPath: "api.sock"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		content   string
+		converted bool
+	}{
+		{
+			name:      "yaml",
+			path:      "config.yml",
+			converted: false,
+			content: `
+vault_mount: kv
+auth:
+  method: approle
+  approle:
+    role_id_file: /etc/role_id
+clusters:
+  dr:
+    address: https://dr.example:8200
+secrets:
+  - vault_path: app/db
+    socket_path: db.sock
+  - vault_path: app/api
+    socket_path: api.sock
+`,
+		},
+		{
+			name:      "json",
+			path:      "config.json",
+			converted: false,
+			content: `{
+  "vault_mount": "kv",
+  "auth": {"method": "approle", "approle": {"role_id_file": "/etc/role_id"}},
+  "clusters": {"dr": {"address": "https://dr.example:8200"}},
+  "secrets": [
+    {"vault_path": "app/db", "socket_path": "db.sock"},
+    {"vault_path": "app/api", "socket_path": "api.sock"}
+  ]
+}`,
+		},
+		{
+			name:      "toml",
+			path:      "config.TOML",
+			converted: true,
+			content: `
+vault_mount = "kv"
+
+[auth]
+method = "approle"
+
+[auth.approle]
+role_id_file = "/etc/role_id"
+
+[clusters.dr]
+address = "https://dr.example:8200"
+
+[[secrets]]
+vault_path = "app/db"
+socket_path = "db.sock"
+
+[[secrets]]
+vault_path = "app/api"
+socket_path = "api.sock"
+`,
+		},
+		{
+			name:      "hcl",
+			path:      "config.hcl",
+			converted: true,
+			content: `
+vault_mount = "kv"
+
+auth {
+  method = "approle"
+  approle {
+    role_id_file = "/etc/role_id"
+  }
+}
+
+clusters "dr" {
+  address = "https://dr.example:8200"
+}
+
+secrets {
+  vault_path  = "app/db"
+  socket_path = "db.sock"
+}
+
+secrets {
+  vault_path  = "app/api"
+  socket_path = "api.sock"
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, converted, err := configYAML(tt.path, []byte(tt.content))
+			if err != nil {
+				t.Fatalf("configYAML() error = %v", err)
+			}
+			if converted != tt.converted {
+				t.Errorf("configYAML() converted = %v, want %v", converted, tt.converted)
+			}
+			var got Config
+			if err = yaml.UnmarshalStrict(content, &got); err != nil {
+				t.Fatalf("reading converted configuration: %v\n%s", err, content)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("configYAML() read as %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigYAMLInvalid(t *testing.T) {
+	for _, path := range []string{"config.toml", "config.hcl"} {
+		if _, _, err := configYAML(path, []byte("secrets = [")); err == nil {
+			t.Errorf("configYAML(%s) of invalid content succeeded", path)
+		}
+	}
+}
+
+func TestHCLShape(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		t    reflect.Type
+		want interface{}
+	}{
+		{
+			name: "blocks merged into an object",
+			v:    []map[string]interface{}{{"method": "approle"}, {"namespace": "team"}},
+			t:    reflect.TypeOf(&AuthConfig{}),
+			want: map[string]interface{}{"method": "approle", "namespace": "team"},
+		},
+		{
+			name: "single block as a list",
+			v:    map[string]interface{}{"vault_path": "app/db"},
+			t:    reflect.TypeOf([]Secret{}),
+			want: []interface{}{map[string]interface{}{"vault_path": "app/db"}},
+		},
+		{
+			name: "blocks as a list",
+			v:    []map[string]interface{}{{"vault_path": "app/db"}, {"vault_path": "app/api"}},
+			t:    reflect.TypeOf([]Secret{}),
+			want: []interface{}{map[string]interface{}{"vault_path": "app/db"}, map[string]interface{}{"vault_path": "app/api"}},
+		},
+		{
+			name: "nested within a map",
+			v:    []map[string]interface{}{{"dr": []map[string]interface{}{{"address": "https://dr"}}}},
+			t:    reflect.TypeOf(map[string]*Cluster{}),
+			want: map[string]interface{}{"dr": map[string]interface{}{"address": "https://dr"}},
+		},
+		{
+			name: "list of strings left alone",
+			v:    []interface{}{"a", "b"},
+			t:    reflect.TypeOf([]string{}),
+			want: []interface{}{"a", "b"},
+		},
+		{
+			name: "scalar left alone",
+			v:    "kv",
+			t:    reflect.TypeOf(""),
+			want: "kv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hclShape(tt.v, tt.t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hclShape() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
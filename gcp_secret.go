@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+type GCPSecret struct {
+	Roleset    string `yaml:"roleset"`    // The roleset to generate credentials for
+	Credential string `yaml:"credential"` // key (a service account key, the default) or token (an OAuth2 access token)
+	TTL        string `yaml:"ttl"`        // The requested lifetime of service account keys (optional)
+}
+
+// gcpQuery returns the request parameters for a gcp secret.
+func gcpQuery(secret Secret) url.Values {
+	if secret.GCP.TTL == "" {
+		return nil
+	}
+	return url.Values{"ttl": {secret.GCP.TTL}}
+}
+
+// gcpCredentials renders a service account key as the key file read by
+// GOOGLE_APPLICATION_CREDENTIALS, and an access token as the bare token.
+func gcpCredentials(data map[string]interface{}) (*secretValue, error) {
+	if token, ok := data["token"].(string); ok {
+		return &secretValue{Data: data, Default: []byte(token)}, nil
+	}
+
+	encoded, _ := data["private_key_data"].(string)
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding service account key")
+	}
+	return &secretValue{Data: data, Default: key}, nil
+}
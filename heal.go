@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// serveHealing serves on ln with serve until ctx is cancelled. Should the
+// socket the daemon bound be removed or replaced, as by a cleanup of its
+// directory, it is bound again, rather than the secret silently no longer
+// being served until the daemon restarts. Sockets passed by systemd are left
+// to systemd, abstract and vsock sockets can't be removed, and serve_once
+// sockets remove themselves. The listener being served is closed by the time
+// serveHealing returns, leaving the socket's path to the caller.
+func (app *App) serveHealing(ctx context.Context, secret Secret, ln net.Listener, serve func(context.Context, net.Listener)) {
+	if _, ok := app.activatedListener(secret); ok || !secret.onFilesystem() || secret.ServeOnce {
+		serve(ctx, ln)
+		// Closing removes a serve_once socket, and waiting for it here means
+		// it can't remove one bound in its place afterwards
+		ln.Close()
+		return
+	}
+
+	sockPath := app.config.SocketRoot + secret.SocketPath
+	for {
+		serveCtx, stop := context.WithCancel(ctx)
+		go serve(serveCtx, ln)
+
+		if err := awaitSocketLoss(serveCtx, sockPath); err != nil {
+			logWarning("Not watching %s for removal: %+v", sockPath, err)
+			<-ctx.Done()
+		}
+		// Whatever is at the path by now may be a socket bound in its place,
+		// which closing mustn't remove
+		keepSocket(ln)
+		stop()
+		ln.Close()
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("Socket %s was removed or replaced, listening again", sockPath)
+		if app.config.FDStore {
+			unstoreSocket(secret)
+		}
+
+		if ln = app.relisten(ctx, secret); ln == nil {
+			return
+		}
+	}
+}
+
+// keepSocket has ln's socket left in place when ln is closed.
+func keepSocket(ln net.Listener) {
+	if unixListener, ok := ln.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(false)
+	}
+}
+
+// relisten binds secret's socket again, retrying with backoff until it
+// succeeds or ctx is cancelled.
+func (app *App) relisten(ctx context.Context, secret Secret) net.Listener {
+	retry := backoff.NewExponentialBackOff()
+	retry.MaxInterval = time.Minute
+	retry.MaxElapsedTime = 0
+	for {
+		ln, err := app.listenSocket(secret)
+		if err == nil {
+			return ln
+		}
+
+		wait := retry.NextBackOff()
+		logError("Error listening on %s again, retrying in %s: %+v", secret.SocketPath, wait.Round(time.Second), err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// awaitSocketLoss returns once the socket at path is removed or replaced by
+// another file, or ctx is cancelled.
+func awaitSocketLoss(ctx context.Context, path string) error {
+	original, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "checking socket")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "creating file watcher")
+	}
+	defer watcher.Close()
+
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		return errors.Wrapf(err, "watching %s", path)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			logError("Error watching %s: %+v", path, err)
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) != filepath.Clean(path) {
+				continue
+			}
+			current, err := os.Stat(path)
+			if err != nil || !os.SameFile(original, current) {
+				return nil
+			}
+		}
+	}
+}
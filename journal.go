@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/pkg/errors"
+)
+
+// journalLogging is set once logging goes to journald, with structured fields.
+var journalLogging bool
+
+// setupLogging sends logging to journald's native protocol if so configured,
+// or by default when the daemon's output is going to the journal anyway.
+func setupLogging(target string) error {
+	switch target {
+	case "", "auto":
+		journalLogging = journal.Enabled() && stderrIsJournal()
+	case "journal":
+		if !journal.Enabled() {
+			return errors.New("journald is not available")
+		}
+		journalLogging = true
+	case "stderr":
+	default:
+		return errors.Errorf("unsupported log_target %q", target)
+	}
+
+	if journalLogging {
+		// The journal records its own timestamps
+		log.SetFlags(0)
+		log.SetOutput(journalWriter{})
+	}
+	return nil
+}
+
+// stderrIsJournal reports whether stderr is connected to the journal, by
+// systemd's StandardError=journal, which it reports in $JOURNAL_STREAM.
+func stderrIsJournal() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(int(os.Stderr.Fd()), &stat); err != nil {
+		return false
+	}
+	return stream == fmt.Sprintf("%d:%d", stat.Dev, stat.Ino)
+}
+
+// journalWriter sends each message logged with the log package to journald,
+// as information: problems are logged with logError and logWarning instead.
+type journalWriter struct{}
+
+func (journalWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSuffix(string(p), "\n")
+	if err := journal.Send(message, journal.PriInfo, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// logError logs a message about something that failed.
+func logError(format string, args ...interface{}) {
+	logPriority(journal.PriErr, format, args...)
+}
+
+// logWarning logs a message about something amiss that the daemon carries on
+// regardless of, such as a connection it refused.
+func logWarning(format string, args ...interface{}) {
+	logPriority(journal.PriWarning, format, args...)
+}
+
+// logFatal logs a message about a failure the daemon can't run with, and
+// exits.
+func logFatal(format string, args ...interface{}) {
+	logPriority(journal.PriCrit, format, args...)
+	os.Exit(1)
+}
+
+// logPriority logs a message with the given priority when logging to
+// journald, where it can be filtered on.
+func logPriority(priority journal.Priority, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if !journalLogging || journal.Send(message, priority, nil) != nil {
+		log.Print(message)
+	}
+}
+
+// logSecret logs a message about serving secret on c, with structured fields
+// identifying the secret and the process connected when logging to journald,
+// so that credential activity can be filtered with journalctl.
+func (app *App) logSecret(priority journal.Priority, secret Secret, c net.Conn, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if !journalLogging {
+		log.Print(message)
+		return
+	}
+
+	fields := map[string]string{
+		"SECRET_NAME": secret.credentialName(),
+		"VAULT_PATH":  app.readPath(secret),
+		"SOCKET":      app.socketAddress(secret),
+	}
+	if c != nil {
+		if cred, err := peerCredentials(c); err == nil {
+			fields["PEER_PID"] = strconv.Itoa(int(cred.PID))
+			fields["PEER_UID"] = strconv.FormatUint(uint64(cred.UID), 10)
+			fields["PEER_GID"] = strconv.FormatUint(uint64(cred.GID), 10)
+		}
+	}
+	if err := journal.Send(message, priority, fields); err != nil {
+		log.Print(message)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "sync"
+
+// keyLocks serialises work done for each key, such as issuing credentials for
+// a socket path, without holding up the work of other keys.
+type keyLocks struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock locks key, returning the function unlocking it.
+func (k *keyLocks) lock(key string) func() {
+	k.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*sync.Mutex{}
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		k.locks[key] = l
+	}
+	k.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// kvVersion returns the KV engine version secret is read with: its own
+// kv_version, the global kv_version, or the version detected for its mount.
+func (app *App) kvVersion(secret Secret) int {
+	if secret.KVVersion != 0 {
+		return secret.KVVersion
+	}
+	if app.config.KVVersion != 0 {
+		return app.config.KVVersion
+	}
+	app.kvMu.RLock()
+	defer app.kvMu.RUnlock()
+	if version, ok := app.kvVersions[app.kvVersionKey(secret)]; ok {
+		return version
+	}
+	return 2
+}
+
+// detectKVVersions looks up the KV version of each mount secrets are read
+// from without an explicit kv_version.
+func (app *App) detectKVVersions(ctx context.Context) error {
+	secrets := app.config.Secrets
+	for _, d := range app.config.Discover {
+		secret := d.Secret
+		secret.Type, secret.VaultPath = "kv", d.Prefix
+		secrets = append(secrets, secret)
+	}
+	if app.config.CredentialSocket != nil {
+		secrets = append(secrets, app.config.CredentialSocket.secrets()...)
+	}
+	if app.config.CredStore != nil {
+		secrets = append(secrets, app.config.CredStore.secrets()...)
+	}
+	secrets = append(secrets, fileSecrets(app.config.Files)...)
+
+	for _, secret := range secrets {
+		if secret.Type == "transit" && secret.Transit != nil && secret.VaultPath != "" {
+			secret = secret.ciphertextSource()
+		}
+		if secret.Type != "" && secret.Type != "kv" {
+			continue
+		}
+		for _, version := range []int{secret.KVVersion, app.config.KVVersion} {
+			if version < 0 || version > 2 {
+				return errors.Errorf("unsupported kv_version %d for secret %s", version, secret.VaultPath)
+			}
+		}
+		if secret.KVVersion != 0 || app.config.KVVersion != 0 {
+			continue
+		}
+
+		app.detectMountVersion(ctx, secret)
+	}
+	return nil
+}
+
+// detectMountVersion looks up the KV version of secret's mount, unless it is
+// already known.
+func (app *App) detectMountVersion(ctx context.Context, secret Secret) {
+	key := app.kvVersionKey(secret)
+	app.kvMu.RLock()
+	_, ok := app.kvVersions[key]
+	app.kvMu.RUnlock()
+	if ok {
+		return
+	}
+
+	mount := app.mountFor(secret)
+	version, err := detectKVVersion(ctx, app.clientFor(secret), mount)
+	if err != nil {
+		logWarning("Unable to detect KV version of %s, assuming 2: %+v", mount, err)
+		version = 2
+	}
+	app.kvMu.Lock()
+	app.kvVersions[key] = version
+	app.kvMu.Unlock()
+}
+
+// kvVersionKey returns the key of secret's mount among the detected KV
+// versions. The same mount may differ between clusters.
+func (app *App) kvVersionKey(secret Secret) string {
+	if secret.Cluster != "" {
+		return secret.Cluster + ":" + app.mountFor(secret)
+	}
+	return app.mountFor(secret)
+}
+
+// detectKVVersion asks Vault for the options of a KV mount, in the same way
+// the Vault CLI does. Unlike sys/mounts this needs no extra privileges.
+func detectKVVersion(ctx context.Context, client *api.Client, mount string) (int, error) {
+	secret, err := client.Logical().ReadWithContext(ctx, "sys/internal/ui/mounts/"+mount)
+	if err != nil {
+		return 0, err
+	}
+	if secret == nil {
+		return 0, errors.New("no mount information returned")
+	}
+
+	options, _ := secret.Data["options"].(map[string]interface{})
+	version, _ := options["version"].(string)
+	if version == "" {
+		return 1, nil
+	}
+	return strconv.Atoi(version)
+}
+
+// fetchKV reads secret from its KV mount, at its pinned version if it has one.
+func (app *App) fetchKV(ctx context.Context, secret Secret) (*api.KVSecret, error) {
+	client := app.clientFor(secret)
+	if app.kvVersion(secret) == 1 {
+		if secret.Version != 0 {
+			return nil, errors.Errorf("version pinned for secret %s, but KV version 1 mounts are not versioned", secret.VaultPath)
+		}
+		return client.KVv1(app.mountFor(secret)).Get(ctx, secret.VaultPath)
+	}
+	if secret.Version != 0 {
+		return client.KVv2(app.mountFor(secret)).GetVersion(ctx, secret.VaultPath, secret.Version)
+	}
+	return client.KVv2(app.mountFor(secret)).Get(ctx, secret.VaultPath)
+}
+
+// kvReadPath returns the API path secret is read from.
+func (app *App) kvReadPath(secret Secret) string {
+	mount := app.mountFor(secret)
+	path := strings.TrimPrefix(secret.VaultPath, "/")
+	if app.kvVersion(secret) == 1 {
+		return mount + "/" + path
+	}
+	return mount + "/data/" + path
+}
+
+// mountFor returns the KV mount secret is read from, without surrounding slashes.
+func (app *App) mountFor(secret Secret) string {
+	if secret.Mount != "" {
+		return strings.Trim(secret.Mount, "/")
+	}
+	if cluster := app.config.Clusters[secret.Cluster]; cluster != nil && cluster.Mount != "" {
+		return strings.Trim(cluster.Mount, "/")
+	}
+	return strings.Trim(app.config.VaultMount, "/")
+}
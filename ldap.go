@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type LDAPSecret struct {
+	Role         string        `yaml:"role"`          // The LDAP role to fetch credentials for
+	Static       bool          `yaml:"static"`        // Serve the password of a static role, which Vault rotates, rather than dynamic credentials
+	PollInterval time.Duration `yaml:"poll_interval"` // How often a static role's rotation is checked for between scheduled rotations (default: 1m)
+}
+
+const defaultLDAPPollInterval = time.Minute
+
+// staticCredCache holds static role credentials by socket path, so they can be
+// served again until the role is due to be rotated or is next checked. The
+// lock guards creds only, and isn't held while the role is read.
+type staticCredCache struct {
+	sync.Mutex
+	creds   map[string]*staticCred
+	reading keyLocks // Serialises reading each socket path's credentials
+}
+
+type staticCred struct {
+	value        *secretValue
+	lastRotation string    // The role's last_vault_rotation when the credentials were read
+	refreshAt    time.Time // When the credentials are next read
+}
+
+// staticCredentials returns the current credentials of a static LDAP role. As
+// Vault rotates the password on its own schedule, and may be asked to rotate
+// it at any time, the role is re-read once its rotation period elapses and
+// every poll interval in between.
+func (app *App) staticCredentials(ctx context.Context, secret Secret) (*secretValue, error) {
+	defer app.staticCreds.reading.lock(secret.SocketPath)()
+
+	app.staticCreds.Lock()
+	cached, ok := app.staticCreds.creds[secret.SocketPath]
+	app.staticCreds.Unlock()
+	if ok && time.Now().Before(cached.refreshAt) {
+		return cached.value, nil
+	}
+
+	path := app.readPath(secret)
+	s, err := app.clientFor(secret).Logical().ReadWithDataWithContext(ctx, path, requestQuery(secret, nil))
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading credentials from %s", path)
+	}
+	if s == nil {
+		return nil, errors.Errorf("no credentials returned from %s", path)
+	}
+
+	value, err := envValue(s.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	lastRotation, _ := s.Data["last_vault_rotation"].(string)
+	if ok && cached.lastRotation != lastRotation {
+		log.Printf("Password for %s was rotated at %s", path, lastRotation)
+	}
+
+	interval := secret.LDAP.PollInterval
+	if interval <= 0 {
+		interval = defaultLDAPPollInterval
+	}
+	if ttl, err := dataSeconds(s.Data, "ttl"); err == nil && ttl < interval {
+		// Read again as soon as the scheduled rotation is due
+		interval = ttl + time.Second
+	}
+
+	app.staticCreds.Lock()
+	if app.staticCreds.creds == nil {
+		app.staticCreds.creds = map[string]*staticCred{}
+	}
+	app.staticCreds.creds[secret.SocketPath] = &staticCred{
+		value:        value,
+		lastRotation: lastRotation,
+		refreshAt:    time.Now().Add(interval),
+	}
+	app.staticCreds.Unlock()
+
+	return value, nil
+}
+
+// dataSeconds reads a duration in seconds from a Vault response.
+func dataSeconds(data map[string]interface{}, field string) (time.Duration, error) {
+	switch v := data[field].(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return 0, errors.Wrapf(err, "parsing %s", field)
+		}
+		return time.Duration(n) * time.Second, nil
+	case float64:
+		return time.Duration(v) * time.Second, nil
+	default:
+		return 0, errors.Errorf("no %s returned", field)
+	}
+}
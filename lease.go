@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// leaseManager tracks the leases of the dynamic credentials issued for each
+// socket path. Renewable leases are kept renewed for as long as Vault allows,
+// so credentials handed to running services don't silently expire; once a
+// lease can no longer be renewed, the next read issues new credentials.
+// Leases superseded by new credentials, as on reload, are kept renewed too, as
+// the credentials they were issued for may still be in use. The lock guards
+// the leases only, and isn't held while credentials are issued or revoked.
+type leaseManager struct {
+	sync.Mutex
+	leases     map[string]*trackedLease
+	superseded map[string][]*trackedLease // Leases replaced for each key, renewed until their maximum TTL
+	issuing    keyLocks                   // Serialises issuing each key's credentials
+}
+
+type trackedLease struct {
+	client  *api.Client  // The client the credentials were issued to
+	path    string       // The path the credentials were read from
+	secret  *api.Secret  // The response the credentials were issued in
+	value   *secretValue // The credentials as served
+	expires time.Time    // When the lease runs out
+	reissue time.Time    // When new credentials are due
+	stop    func()       // Stops renewal of the lease
+	renewed bool         // Whether the lease is being renewed
+}
+
+// current returns the credentials tracked for key, if they are not yet due to
+// be replaced. The caller must hold the lock.
+func (m *leaseManager) current(key string) (*secretValue, bool) {
+	tracked, ok := m.leases[key]
+	if !ok || !time.Now().Before(tracked.reissue) {
+		return nil, false
+	}
+	return tracked.value, true
+}
+
+// track starts managing the lease of credentials issued for key, replacing any
+// previously tracked for it. A replaced lease that is being renewed is kept
+// renewed until its maximum TTL. The caller must hold the lock.
+func (m *leaseManager) track(key string, client *api.Client, path string, secret *api.Secret, value *secretValue) {
+	if previous, ok := m.leases[key]; ok && previous.renewed {
+		if m.superseded == nil {
+			m.superseded = map[string][]*trackedLease{}
+		}
+		m.superseded[key] = append(m.superseded[key], previous)
+	}
+
+	now := time.Now()
+	duration := time.Duration(secret.LeaseDuration) * time.Second
+	tracked := &trackedLease{
+		client:  client,
+		path:    path,
+		secret:  secret,
+		value:   value,
+		expires: now.Add(duration),
+		reissue: now.Add(duration * 2 / 3),
+		stop:    func() {},
+	}
+
+	if secret.Renewable {
+		watcher, err := client.NewLifetimeWatcher(&api.LifetimeWatcherInput{Secret: secret})
+		if err != nil {
+			logWarning("Unable to renew lease %s: %+v", secret.LeaseID, err)
+		} else {
+			// Renewal keeps the credentials valid until it stops
+			tracked.reissue = tracked.expires
+			tracked.stop = watcher.Stop
+			tracked.renewed = true
+			go watcher.Start()
+			go m.renew(key, tracked, watcher)
+		}
+	}
+
+	if m.leases == nil {
+		m.leases = map[string]*trackedLease{}
+	}
+	m.leases[key] = tracked
+}
+
+// renew records the progress of a lease's renewal until it stops.
+func (m *leaseManager) renew(key string, tracked *trackedLease, watcher *api.LifetimeWatcher) {
+	for {
+		select {
+		case r := <-watcher.RenewCh():
+			m.Lock()
+			tracked.expires = r.RenewedAt.Add(time.Duration(r.Secret.LeaseDuration) * time.Second)
+			tracked.reissue = tracked.expires
+			m.Unlock()
+			log.Printf("Renewed lease %s, valid for %ds", tracked.secret.LeaseID, r.Secret.LeaseDuration)
+
+		case err := <-watcher.DoneCh():
+			if err != nil {
+				logError("Error renewing lease %s: %+v", tracked.secret.LeaseID, err)
+			} else {
+				log.Printf("Lease %s reached its maximum TTL", tracked.secret.LeaseID)
+			}
+			m.Lock()
+			tracked.reissue = time.Now()
+			tracked.renewed = false
+			m.forget(key, tracked)
+			m.Unlock()
+			return
+		}
+	}
+}
+
+// forget stops tracking tracked as a superseded lease of key, once it is no
+// longer renewed. The caller must hold the lock.
+func (m *leaseManager) forget(key string, tracked *trackedLease) {
+	superseded := m.superseded[key]
+	for i, s := range superseded {
+		if s == tracked {
+			superseded = append(superseded[:i:i], superseded[i+1:]...)
+			break
+		}
+	}
+	if len(superseded) == 0 {
+		delete(m.superseded, key)
+	} else {
+		m.superseded[key] = superseded
+	}
+}
+
+// take stops tracking the leases of key, or of every key if none is given,
+// returning them.
+func (m *leaseManager) take(keys ...string) []*trackedLease {
+	m.Lock()
+	defer m.Unlock()
+
+	if len(keys) == 0 {
+		for key := range m.leases {
+			keys = append(keys, key)
+		}
+		for key := range m.superseded {
+			if _, ok := m.leases[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	var taken []*trackedLease
+	for _, key := range keys {
+		if tracked, ok := m.leases[key]; ok {
+			taken = append(taken, tracked)
+		}
+		taken = append(taken, m.superseded[key]...)
+		delete(m.leases, key)
+		delete(m.superseded, key)
+	}
+	return taken
+}
+
+// revoke stops tracking the leases for key, including superseded ones, and
+// revokes them, so that Vault removes the credentials straight away.
+func (m *leaseManager) revoke(ctx context.Context, key string) {
+	revokeLeases(ctx, m.take(key))
+}
+
+// revokeAll revokes every tracked lease.
+func (m *leaseManager) revokeAll(ctx context.Context) {
+	revokeLeases(ctx, m.take())
+}
+
+// revokeLeases stops renewing leases and revokes them.
+func revokeLeases(ctx context.Context, leases []*trackedLease) {
+	for _, tracked := range leases {
+		tracked.stop()
+		if err := tracked.client.Sys().RevokeWithContext(ctx, tracked.secret.LeaseID); err != nil {
+			logError("Error revoking lease %s: %+v", tracked.secret.LeaseID, err)
+		} else {
+			log.Printf("Revoked lease %s", tracked.secret.LeaseID)
+		}
+	}
+}
+
+// leaseCounts counts the tracked leases, for exposing as a metric. Lease IDs
+// are credentials in their own right, able to renew and revoke the lease, so
+// only their number is exposed.
+type leaseCounts struct {
+	Active     int `json:"active"`
+	Renewable  int `json:"renewable"`
+	Superseded int `json:"superseded"`
+}
+
+// counts returns how many leases are tracked, for exposing as a metric.
+func (m *leaseManager) counts() interface{} {
+	m.Lock()
+	defer m.Unlock()
+
+	var counts leaseCounts
+	for _, tracked := range m.leases {
+		counts.Active++
+		if tracked.secret.Renewable {
+			counts.Renewable++
+		}
+	}
+	for _, superseded := range m.superseded {
+		counts.Superseded += len(superseded)
+	}
+	return counts
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMaxConnectionsPerSocket bounds the connections served at once on a
+// socket when max_connections_per_socket isn't set.
+const defaultMaxConnectionsPerSocket = 16
+
+// connLimits bounds the connections served at once on a socket, and across
+// all sockets, so a herd of services restarting together doesn't become a
+// herd of requests to Vault. Connections beyond the limits wait their turn,
+// or are refused outright if so configured.
+type connLimits struct {
+	socket   chan struct{}
+	global   chan struct{} // Shared between sockets, or nil if uncapped
+	refuse   bool
+	inflight *inflightConns // Counts connections being served, for draining
+}
+
+var errTooManyConnections = errors.New("too many connections")
+
+// inflightConns counts the connections being served, so that shutdown can
+// wait for them. Once draining has begun, no more are counted in, as a
+// WaitGroup can't be added to while it is being waited on.
+type inflightConns struct {
+	sync.Mutex
+	conns    sync.WaitGroup
+	draining bool
+}
+
+var errDraining = errors.New("shutting down")
+
+// add counts in a connection, unless draining has begun.
+func (f *inflightConns) add() error {
+	f.Lock()
+	defer f.Unlock()
+	if f.draining {
+		return errDraining
+	}
+	f.conns.Add(1)
+	return nil
+}
+
+// done counts out a connection.
+func (f *inflightConns) done() {
+	f.conns.Done()
+}
+
+// wait refuses further connections, and waits for those being served.
+func (f *inflightConns) wait() {
+	f.Lock()
+	f.draining = true
+	f.Unlock()
+	f.conns.Wait()
+}
+
+// newConnLimits returns the limits for a socket allowing max connections at
+// once, or the configured default if max is 0.
+func (app *App) newConnLimits(max int) *connLimits {
+	if max <= 0 {
+		max = app.config.MaxConnectionsPerSocket
+	}
+	if max <= 0 {
+		max = defaultMaxConnectionsPerSocket
+	}
+	return &connLimits{
+		socket:   make(chan struct{}, max),
+		global:   app.connections,
+		refuse:   app.config.ConnectionOverflow == "refuse",
+		inflight: &app.inflight,
+	}
+}
+
+// acquire takes a slot for a connection, waiting for one unless refusing.
+func (l *connLimits) acquire() error {
+	if l.refuse {
+		select {
+		case l.socket <- struct{}{}:
+		default:
+			return errTooManyConnections
+		}
+		if l.global != nil {
+			select {
+			case l.global <- struct{}{}:
+			default:
+				<-l.socket
+				return errTooManyConnections
+			}
+		}
+	} else {
+		l.socket <- struct{}{}
+		if l.global != nil {
+			l.global <- struct{}{}
+		}
+	}
+
+	if err := l.inflight.add(); err != nil {
+		l.releaseSlots()
+		return err
+	}
+	return nil
+}
+
+// release gives back a connection's slot.
+func (l *connLimits) release() {
+	l.inflight.done()
+	l.releaseSlots()
+}
+
+// releaseSlots gives back the slots taken by acquire.
+func (l *connLimits) releaseSlots() {
+	if l.global != nil {
+		<-l.global
+	}
+	<-l.socket
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestConnLimitsRefuse(t *testing.T) {
+	l := &connLimits{socket: make(chan struct{}, 1), global: make(chan struct{}, 2), refuse: true, inflight: &inflightConns{}}
+
+	if err := l.acquire(); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	if err := l.acquire(); err != errTooManyConnections {
+		t.Fatalf("acquire() beyond the socket's limit error = %v, want %v", err, errTooManyConnections)
+	}
+	l.release()
+	if err := l.acquire(); err != nil {
+		t.Fatalf("acquire() after release error = %v", err)
+	}
+	l.release()
+	if len(l.socket) != 0 || len(l.global) != 0 {
+		t.Errorf("slots still taken after release: socket %d, global %d", len(l.socket), len(l.global))
+	}
+}
+
+func TestConnLimitsDraining(t *testing.T) {
+	inflight := &inflightConns{}
+	l := &connLimits{socket: make(chan struct{}, 2), global: make(chan struct{}, 2), inflight: inflight}
+
+	if err := l.acquire(); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	drained := make(chan struct{})
+	go func() {
+		inflight.wait()
+		close(drained)
+	}()
+
+	// Once draining, connections are refused without taking a slot
+	for {
+		err := l.acquire()
+		if err == errDraining {
+			break
+		}
+		if err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+		l.release()
+	}
+	if len(l.socket) != 1 || len(l.global) != 1 {
+		t.Errorf("slots taken by refused connection: socket %d, global %d", len(l.socket), len(l.global))
+	}
+
+	l.release()
+	<-drained
+}
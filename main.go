@@ -3,147 +3,651 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/cenkalti/backoff/v3"
+	"github.com/coreos/go-systemd/v22/journal"
 	"github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
 )
 
 type App struct {
-	config *Config
-	client *api.Client
+	config     *Config
+	configPath string // Where the configuration is reloaded from
+	configDir  string // The directory of further configuration merged into it (optional)
+	client     *api.Client
+	auth       *autoAuth // Manages the Vault login, if an auth method is configured
+
+	identities map[string]*autoAuth     // Clients logged in as the named identities
+	clusters   map[string]clusterClient // Clients for the named clusters
+	kvVersions map[string]int           // Detected KV versions by mount
+	kvMu       sync.RWMutex             // Guards kvVersions, which grows on reload
+	certs      certCache                // Issued certificates for pki secrets
+	leases     leaseManager             // Issued dynamic credentials and their leases
+	served     servedSockets            // Configured secrets being served
+	reloading  sync.Mutex               // Serialises configuration reloads
+	discovered discoveredSockets        // Secrets served from discovery
+	activated  activatedSockets         // Sockets passed by systemd socket activation
+
+	connections  chan struct{}      // Slots for connections across all sockets, if capped
+	inflight     inflightConns      // Connections being served, drained on shutdown
+	connCtx      context.Context    // The context connections are served in, which outlives accepting them
+	abandonConns context.CancelFunc // Cancels connCtx once draining gives up
+
+	filesRotated chan struct{} // Signalled when a secret under files is known to have been rotated
+	failures     fetchFailures // Consecutive failures to fetch each secret, for webhooks
+
+	staticCreds staticCredCache // Current credentials of static ldap roles
 }
 
-func socketSecretListen(ctx context.Context, client *api.Client, mount *api.KVv2, socketRoot string, secret Secret) {
+// listenSocket returns the listening socket for secret: the one passed by
+// systemd, if any, or otherwise a newly bound one.
+func (app *App) listenSocket(secret Secret) (net.Listener, error) {
+
+	sockPath := app.socketAddress(secret)
+
+	if ln, ok := app.activatedListener(secret); ok {
+		log.Printf("Listening on socket %s passed by systemd for secret path %s", secret.fdName(), app.readPath(secret))
+		return ln, nil
+	}
 
-	sockPath := socketRoot + secret.SocketPath
+	if secret.VSockPort != 0 {
+		return app.listenVSock(secret)
+	}
+
+	if secret.abstract() {
+		log.Printf("Listening on abstract socket %s for secret path %s", sockPath, app.readPath(secret))
+		ln, err := net.Listen("unix", sockPath)
+		if err != nil {
+			return nil, err
+		}
+		if app.config.FDStore {
+			storeSocket(secret, ln)
+		}
+		return ln, nil
+	}
 
 	err := os.RemoveAll(sockPath)
 	if err != nil {
-		log.Fatalf("%+v", err)
-		return
+		logFatal("%+v", err)
+	}
+	if err = app.createSocketDir(sockPath); err != nil {
+		return nil, err
 	}
 
-	log.Printf("Listening on %s for secret path %s", sockPath, secret.VaultPath)
+	log.Printf("Listening on %s for secret path %s", sockPath, app.readPath(secret))
 
-	// Ensure created unix sockets are mode 0700
-	syscall.Umask(0077)
 	ln, err := net.Listen("unix", sockPath)
 	if err != nil {
-		log.Print(err)
-		return
+		return nil, err
+	}
+	if err = app.applySocketPermissions(secret, sockPath); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if app.config.FDStore {
+		// The socket outlives the daemon, so mustn't be removed on shutdown
+		ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		storeSocket(secret, ln)
+	}
+	return ln, nil
+}
+
+// socketAddress returns the address of secret's socket: its path beneath
+// socket_root, its vsock port, or an abstract address, which has no file, as
+// given.
+func (app *App) socketAddress(secret Secret) string {
+	if secret.VSockPort != 0 {
+		return "vsock:" + strconv.FormatUint(uint64(secret.VSockPort), 10)
+	}
+	if secret.abstract() {
+		return secret.SocketPath
+	}
+	return app.config.SocketRoot + secret.SocketPath
+}
+
+// socketSecretListen serves secret to each connection to ln, until ctx is cancelled.
+func (app *App) socketSecretListen(ctx context.Context, secret Secret, ln net.Listener) {
+	timeout := secret.ConnectionTimeout
+	if timeout == 0 {
+		timeout = app.connectionTimeout()
+	}
+	limits := app.newConnLimits(secret.MaxConnections)
+	serve := func(c net.Conn) {
+		app.serveConn(app.connCtx, secret, c)
 	}
+	if secret.ServeOnce {
+		ctx, limits, serve = app.serveOnce(ctx, secret, func(c net.Conn) bool {
+			return app.serveConn(app.connCtx, secret, c)
+		})
+	}
+	acceptConns(ctx, ln, secret.SocketPath, timeout, limits, serve)
+}
+
+// defaultConnectionTimeout bounds how long a connection may take to be served.
+const defaultConnectionTimeout = time.Minute
+
+// connectionTimeout returns the configured connection_timeout, or the default.
+func (app *App) connectionTimeout() time.Duration {
+	if app.config.ConnectionTimeout > 0 {
+		return app.config.ConnectionTimeout
+	}
+	return defaultConnectionTimeout
+}
+
+// acceptConns accepts connections to ln until ctx is cancelled, handing each
+// to serve. Connections are served concurrently, so one slow Vault request
+// doesn't hold up every other unit starting, but only within limits, and
+// each must be served within timeout, so a client that never reads can't
+// hold on to a fetched secret. Accept errors, such as running out of file
+// descriptors, are retried with backoff rather than giving up on the socket.
+func acceptConns(ctx context.Context, ln net.Listener, name string, timeout time.Duration, limits *connLimits, serve func(net.Conn)) {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	retry := backoff.NewExponentialBackOff()
+	retry.InitialInterval = 10 * time.Millisecond
+	retry.MaxInterval = time.Second
+	retry.MaxElapsedTime = 0
 
 	for {
 		c, err := ln.Accept()
 		if err != nil {
-			fmt.Println(err)
+			if ctx.Err() != nil {
+				return
+			}
+			wait := retry.NextBackOff()
+			logError("Error accepting connection on %s, retrying in %s: %+v", name, wait, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		retry.Reset()
+
+		if err = c.SetDeadline(time.Now().Add(timeout)); err != nil {
+			logError("Error setting deadline on %s: %+v", name, err)
+		}
+
+		if err = limits.acquire(); err != nil {
+			logWarning("Refusing connection to %s: %+v", name, err)
+			c.Close()
 			continue
 		}
+		go func() {
+			defer limits.release()
+			serve(c)
+		}()
+	}
+}
+
+// serveConn serves secret to a single connection, reporting whether its value
+// was written in full.
+func (app *App) serveConn(ctx context.Context, secret Secret, c net.Conn) bool {
+	sockPath := app.socketAddress(secret)
 
-		log.Printf("Serving secret value for %s on socket %s", secret.VaultPath, sockPath)
+	if err := app.authorize(secret, c, ""); err != nil {
+		app.logSecret(journal.PriWarning, secret, c, "Refusing to serve %s: %+v", sockPath, err)
+		c.Close()
+		return false
+	}
 
-		obj, err := mount.Get(ctx, secret.VaultPath)
+	if secret.Type == "transit" && secret.Transit != nil && secret.Transit.Operation != "" {
+		app.serveTransitRequest(ctx, secret, c)
+		return false
+	}
+
+	app.logSecret(journal.PriInfo, secret, c, "Serving secret value for %s on socket %s", app.readPath(secret), sockPath)
+
+	err := app.writeSecret(ctx, secret, c)
+	if err != nil {
+		app.logSecret(journal.PriErr, secret, c, "Error serving %s: %+v", sockPath, err)
+	}
+	if closeErr := c.Close(); closeErr != nil {
+		logError("%v", closeErr)
+	}
+	return err == nil
+}
+
+// writeSecret fetches secret and writes it to c.
+func (app *App) writeSecret(ctx context.Context, secret Secret, c net.Conn) error {
+	value, err := app.fetchSecret(ctx, secret)
+	app.fetchResult(secret, err)
+	if err != nil {
+		if isPermissionDenied(err) {
+			app.requestReauth(secret)
+		}
+		return err
+	}
+	content, err := renderSecret(secret, value)
+	if err != nil {
+		return err
+	}
+	if secret.Encrypt != "" {
+		if content, err = encryptCredential(ctx, secret, content); err != nil {
+			return err
+		}
+	}
+	_, err = c.Write(content)
+	return err
+}
+
+func newApp(config *Config) *App {
+	app := &App{
+		config:     config,
+		identities: map[string]*autoAuth{},
+		clusters:   map[string]clusterClient{},
+		kvVersions: map[string]int{},
+	}
+	app.connCtx, app.abandonConns = context.WithCancel(context.Background())
+	if config.MaxConnections > 0 {
+		app.connections = make(chan struct{}, config.MaxConnections)
+	}
+	if config.CredStore != nil {
+		config.CredStore.rotated = make(chan struct{}, 1)
+	}
+	app.filesRotated = make(chan struct{}, 1)
+	return app
+}
+
+// clientFor returns the Vault client to fetch secret with.
+func (app *App) clientFor(secret Secret) *api.Client {
+	client := app.client
+	if secret.Cluster != "" {
+		client = app.clusters[secret.Cluster].client
+	} else if secret.Identity != "" {
+		client = app.identities[secret.Identity].client
+	}
+	if secret.Namespace != "" {
+		return client.WithNamespace(secret.Namespace)
+	}
+	return client
+}
+
+// requestReauth asks for a new Vault login for the identity or cluster secret
+// is fetched with, if an auth method is configured for it.
+func (app *App) requestReauth(secret Secret) {
+	if secret.Cluster != "" {
+		if auth := app.clusters[secret.Cluster].auth; auth != nil {
+			auth.requestReauth()
+		}
+	} else if secret.Identity != "" {
+		app.identities[secret.Identity].requestReauth()
+	} else if app.auth != nil {
+		app.auth.requestReauth()
+	}
+}
+
+// revokeTokens revokes the tokens of every login configured to be revoked on shutdown.
+func (app *App) revokeTokens(ctx context.Context) {
+	if app.config.Auth != nil && app.config.Auth.RevokeOnShutdown {
+		revokeToken(ctx, app.client, "default")
+	}
+	for name, identity := range app.identities {
+		if app.config.Identities[name].RevokeOnShutdown {
+			revokeToken(ctx, identity.client, name)
+		}
+	}
+	for name, cluster := range app.clusters {
+		if auth := app.config.Clusters[name].Auth; auth != nil && auth.RevokeOnShutdown {
+			revokeToken(ctx, cluster.client, name+" cluster")
+		}
+	}
+}
+
+func revokeToken(ctx context.Context, client *api.Client, identity string) {
+	if err := client.Auth().Token().RevokeSelfWithContext(ctx, ""); err != nil {
+		logError("Error revoking Vault token for %s identity: %+v", identity, err)
+	} else {
+		log.Printf("Revoked Vault token for %s identity", identity)
+	}
+}
+
+func setupVault(ctx context.Context, app *App) error {
+
+	client, auth, err := newVaultClient(ctx, app.config.defaultCluster(), app.config.Auth)
+	if err != nil {
+		return err
+	}
+	app.client, app.auth = client, auth
+
+	for name, authConfig := range app.config.Identities {
+		if authConfig == nil {
+			return errors.Errorf("identity %s has no auth configuration", name)
+		}
+		_, auth, err := newVaultClient(ctx, app.config.defaultCluster(), authConfig)
 		if err != nil {
-			log.Print(err)
-			return
+			return errors.Wrapf(err, "error setting up %s identity", name)
 		}
-		if secret.Field != "" {
-			value := obj.Data[secret.Field].(string)
-			if _, err = c.Write([]byte(value)); err != nil {
-				log.Print(err)
-				return
-			}
-		} else {
-			if _, err = c.Write([]byte(fmt.Sprintf("%+v", obj))); err != nil {
-				log.Print(err)
-				return
-			}
+		app.identities[name] = auth
+	}
+
+	for name, cluster := range app.config.Clusters {
+		if cluster == nil || cluster.Address == "" {
+			return errors.Errorf("cluster %s has no address", name)
 		}
-		if err = c.Close(); err != nil {
-			log.Print(err)
+		client, auth, err := newVaultClient(ctx, cluster, cluster.Auth)
+		if err != nil {
+			return errors.Wrapf(err, "error setting up %s cluster", name)
 		}
+		app.clusters[name] = clusterClient{client: client, auth: auth}
 	}
 
+	secrets := app.config.Secrets
+	if app.config.CredentialSocket != nil {
+		secrets = append(secrets, app.config.CredentialSocket.secrets()...)
+	}
+	if app.config.CredStore != nil {
+		secrets = append(secrets, app.config.CredStore.secrets()...)
+	}
+	secrets = append(secrets, fileSecrets(app.config.Files)...)
+	for _, secret := range secrets {
+		if err = app.checkClient(secret); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func newApp(config *Config) *App {
-	return &App{
-		config: config,
+// checkClient checks that the identity and cluster secret is fetched with exist.
+func (app *App) checkClient(secret Secret) error {
+	if secret.Vault != nil {
+		return errors.Errorf("secret %s sets vault, which only secrets in the configuration file can", secret.VaultPath)
+	}
+	if _, ok := app.identities[secret.Identity]; secret.Identity != "" && !ok {
+		return errors.Errorf("secret %s uses undefined identity %s", secret.VaultPath, secret.Identity)
+	}
+	if _, ok := app.clusters[secret.Cluster]; secret.Cluster != "" && !ok {
+		return errors.Errorf("secret %s uses undefined cluster %s", secret.VaultPath, secret.Cluster)
 	}
+	if secret.Identity != "" && secret.Cluster != "" {
+		return errors.Errorf("secret %s sets both identity and cluster; identities log in to the default cluster", secret.VaultPath)
+	}
+	return nil
 }
 
-func setupVault(app *App) error {
+// newVaultClient creates a Vault client for cluster, logging in and
+// maintaining the login with authConfig if it is given.
+func newVaultClient(ctx context.Context, cluster *Cluster, authConfig *AuthConfig) (*api.Client, *autoAuth, error) {
+
+	var method AuthMethod
+	if authConfig != nil {
+		var err error
+		if method, err = newAuthMethod(authConfig); err != nil {
+			return nil, nil, errors.Wrap(err, "error configuring Vault auth")
+		}
+	}
 
 	apiConfig := api.DefaultConfig()
-	if app.config.VaultServer != nil {
-		apiConfig.Address = *app.config.VaultServer
+	if cluster.OpenBao {
+		if err := openBaoErrors(cluster); err != nil {
+			return nil, nil, err
+		}
+		if err := readOpenBaoEnvironment(apiConfig); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cluster.Address != "" {
+		apiConfig.Address = cluster.Address
+	}
+	if err := cluster.configureTLS(apiConfig); err != nil {
+		return nil, nil, err
+	}
+
+	if t, ok := method.(tlsConfigurer); ok {
+		if err := t.configureTLS(apiConfig); err != nil {
+			return nil, nil, errors.Wrap(err, "error configuring Vault client certificate")
+		}
 	}
 
 	client, err := api.NewClient(apiConfig)
 	if err != nil {
-		return errors.Wrap(err, "error creating Vault API client")
+		return nil, nil, errors.Wrap(err, "error creating Vault API client")
 	}
 
-	app.client = client
-	return nil
+	if err = configureConsistency(client, cluster.Consistency); err != nil {
+		return nil, nil, err
+	}
+
+	if cluster.OpenBao {
+		openBaoClientDefaults(client)
+	}
+
+	namespace := cluster.Namespace
+	if authConfig != nil && authConfig.Namespace != "" {
+		namespace = authConfig.Namespace
+	}
+	if namespace != "" {
+		client.SetNamespace(namespace)
+	}
+
+	if method == nil {
+		return client, nil, nil
+	}
+
+	auth := newAutoAuth(client, method)
+	if err = auth.login(ctx); err != nil {
+		return nil, nil, errors.Wrapf(err, "error logging in to Vault with %s auth", authConfig.Method)
+	}
+	log.Printf("Logged in to %s using %s auth", cluster.product(), authConfig.Method)
+
+	go auth.run(ctx)
+
+	return client, auth, nil
 }
 
+// cleanupTimeout bounds the requests to Vault made on shutdown.
+const cleanupTimeout = 30 * time.Second
+
 var (
 	configPath = flag.String("config", "config.yml", "YAML Configuration file.")
+	configDir  = flag.String("config-dir", "", "Directory of further configuration files, e.g. for each service's secrets, merged in name order.")
 )
 
 func main() {
 
 	flag.Parse()
 
-	config, err := newConfig(*configPath)
+	if flag.Arg(0) == "schema" {
+		// Needs no configuration, being for checking one
+		if err := runSchema(os.Stdout); err != nil {
+			logFatal("Error: %v", err)
+		}
+		return
+	}
+
+	config, err := newConfig(*configPath, *configDir)
 	if err != nil {
-		log.Fatalf("Error reading configuration: %+v", err)
+		logFatal("Error reading configuration: %+v", err)
+	}
+	if err = setupLogging(config.LogTarget); err != nil {
+		logFatal("Error setting up logging: %+v", err)
 	}
 
+	switch flag.Arg(0) {
+	case "":
+	case "dropins":
+		if err = runDropIns(config, flag.Args()[1:]); err != nil {
+			logFatal("Error generating drop-ins: %+v", err)
+		}
+		return
+	case "exec":
+		status, err := runExec(config, flag.Args()[1:])
+		if err != nil {
+			logFatal("Error running command: %+v", err)
+		}
+		os.Exit(status)
+	case "ctl":
+		if err = runCtl(config, flag.Args()[1:]); err != nil {
+			logFatal("Error: %v", err)
+		}
+		return
+	case "smbios":
+		if err = runSMBIOS(config, flag.Args()[1:]); err != nil {
+			logFatal("Error generating qemu credentials: %+v", err)
+		}
+		return
+	default:
+		logFatal("Unknown command %q", flag.Arg(0))
+	}
+
+	warnUnwatchedRotations(config)
+
 	app := newApp(config)
+	app.configPath, app.configDir = *configPath, *configDir
+
+	// Cancelled on shutdown, stopping the accepting of connections and the
+	// background work against Vault
+	ctx, cancel := context.WithCancel(context.Background())
 
-	if err = setupVault(app); err != nil {
-		log.Fatalf("Error configuring Vault client: %+v", err)
+	if err = setupVault(ctx, app); err != nil {
+		logFatal("Error configuring Vault client: %+v", err)
 	}
 
-	kv := app.client.KVv2(config.VaultMount)
+	// The umask is process-wide, so it is set once before any sockets are
+	// created. It keeps them private until given their configured mode.
+	syscall.Umask(0077)
+
+	if err = app.receiveSockets(); err != nil {
+		logFatal("%+v", err)
+	}
+
+	if err = app.loadRemoteSecrets(ctx); err != nil {
+		logFatal("Error loading secrets from Vault: %+v", err)
+	}
+
+	if err = app.detectKVVersions(ctx); err != nil {
+		logFatal("Error detecting KV versions: %+v", err)
+	}
 
-	ctx := context.Background()
+	if err = app.checkCapabilities(ctx); err != nil {
+		logFatal("Error checking Vault capabilities: %+v", err)
+	}
+
+	// Fails fast on required secrets, unless they are fetched with the
+	// rest before readiness anyway
+	if !config.PrefetchBeforeReady {
+		if err = app.prefetch(ctx, false); err != nil {
+			logFatal("Error fetching required secrets: %+v", err)
+		}
+	}
+
+	publishLeases(&app.leases)
+	if config.MetricsAddress != "" {
+		go serveMetrics(config.MetricsAddress)
+	}
+	go monitorTokenTTL(ctx, app.client, config.TokenTTLWarning)
+
+	if config.WatchEvents {
+		go app.watchEvents(ctx)
+	}
+	if config.ControlSocket != "" {
+		if err = app.listenControl(ctx); err != nil {
+			logFatal("Error listening on control socket: %+v", err)
+		}
+	}
+	if config.DBus != "" {
+		if err = app.serveDBus(ctx, config.DBus); err != nil {
+			logFatal("Error serving D-Bus interface: %+v", err)
+		}
+	}
 
 	// Start a unix socket listener for each configured secret
-	for _, secretCfg := range config.Secrets {
-		go func(secret Secret) {
-			socketSecretListen(ctx, app.client, kv, app.config.SocketRoot, secret)
-		}(secretCfg)
+	for _, secret := range config.Secrets {
+		if err = app.serveSecret(ctx, secret); err != nil {
+			logError("%v", err)
+		}
+	}
+
+	if cs := config.CredentialSocket; cs != nil {
+		if err = cs.compile(); err != nil {
+			logFatal("Error in credential socket rules: %+v", err)
+		}
+		ln, err := app.listenSocket(cs.socket())
+		if err != nil {
+			logFatal("Error listening on credential socket: %+v", err)
+		}
+		go app.serveHealing(ctx, cs.socket(), ln, func(ctx context.Context, ln net.Listener) {
+			app.serveCredentialSocket(ctx, cs, ln)
+		})
+	}
+
+	if s := config.CredStore; s != nil {
+		go app.maintainFiles(ctx, s.targets(), s.Refresh, s.rotated)
+	}
+	if len(config.Files) > 0 {
+		targets, err := app.fileTargets()
+		if err != nil {
+			logFatal("Error in files: %+v", err)
+		}
+		go app.maintainFiles(ctx, targets, config.FileRefresh, app.filesRotated)
+	}
+
+	if config.PrefetchBeforeReady {
+		if err = app.prefetch(ctx, true); err != nil {
+			logFatal("Error fetching secrets: %+v", err)
+		}
+	}
+	notifyReady(len(app.servedSecrets()))
+	go app.runWatchdog(ctx)
+
+	for i := range config.Discover {
+		go app.discover(ctx, &config.Discover[i])
+	}
+
+	// Reload on SIGHUP, as systemctl reload sends for Type=notify-reload
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go func() {
+		for range reloadChan {
+			app.reloadConfig(ctx)
+		}
+	}()
+	if config.WatchConfig {
+		if err = app.watchConfig(ctx); err != nil {
+			logError("Error watching configuration file: %+v", err)
+		}
 	}
 
 	// Register and handle interrupt signals to make sure we clean up
 	// the unix sockets nicely.
 	signalChan := make(chan os.Signal, 1)
 	done := make(chan struct{})
-	signal.Notify(signalChan, os.Interrupt)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		<-signalChan
-		log.Print("Received interrupt: cleaning up...")
-		for _, secret := range config.Secrets {
-			sockPath := app.config.SocketRoot + secret.SocketPath
-
-			err := os.Remove(sockPath)
-			if err != nil {
-				log.Print(err)
-			} else {
-				log.Printf("Removed socket %s", sockPath)
+		sig := <-signalChan
+		log.Printf("Received %s: cleaning up...", sig)
+		notifyStopping()
+		cancel()
+		app.drain()
+		if !config.FDStore {
+			for _, secret := range append(app.servedSecrets(), app.discoveredSecrets()...) {
+				app.removeSocket(secret)
+			}
+			if config.CredentialSocket != nil {
+				app.removeSocket(config.CredentialSocket.socket())
 			}
+		}
 
+		cleanupCtx, cancelCleanup := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancelCleanup()
+		if config.RevokeLeasesOnShutdown {
+			app.leases.revokeAll(cleanupCtx)
 		}
+		app.revokeTokens(cleanupCtx)
 		close(done)
 	}()
 	<-done
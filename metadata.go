@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// kvMetadata returns the version metadata of a KV v2 secret, or nil for KV v1.
+func kvMetadata(kv *api.KVSecret) map[string]interface{} {
+	if kv.VersionMetadata == nil {
+		return nil
+	}
+	metadata := map[string]interface{}{
+		"version":         kv.VersionMetadata.Version,
+		"created_time":    kv.VersionMetadata.CreatedTime.Format(time.RFC3339Nano),
+		"destroyed":       kv.VersionMetadata.Destroyed,
+		"custom_metadata": kv.CustomMetadata,
+	}
+	if !kv.VersionMetadata.DeletionTime.IsZero() {
+		metadata["deletion_time"] = kv.VersionMetadata.DeletionTime.Format(time.RFC3339Nano)
+	}
+	return metadata
+}
+
+// renderMetadata renders a secret's metadata as JSON, alone when served on a
+// companion socket, or otherwise alongside the rendered value.
+func renderMetadata(secret Secret, value *secretValue, content []byte) ([]byte, error) {
+	if value.Metadata == nil {
+		return nil, errors.Errorf("no metadata available for secret %s", secret.VaultPath)
+	}
+	if secret.metadataOnly {
+		return json.Marshal(value.Metadata)
+	}
+	return json.Marshal(map[string]interface{}{
+		"value":    string(content),
+		"metadata": value.Metadata,
+	})
+}
+
+// metadataSockets adds a companion <socket_path>.meta socket serving the
+// metadata of each secret configured with metadata: socket.
+func metadataSockets(secrets []Secret) []Secret {
+	for _, secret := range secrets {
+		if secret.Metadata != "socket" {
+			continue
+		}
+		meta := secret
+		meta.SocketPath += ".meta"
+		meta.metadataOnly = true
+		secrets = append(secrets, meta)
+	}
+	return secrets
+}
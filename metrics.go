@@ -0,0 +1,53 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+var (
+	metricTokenTTL = expvar.NewInt("vault_token_ttl_seconds") // Remaining TTL of the Vault token, 0 if it never expires
+)
+
+// unpublishedVars are the variables expvar publishes itself which aren't
+// served: the command line can carry tokens and other secrets.
+var unpublishedVars = map[string]bool{"cmdline": true}
+
+// publishLeases exposes the number of dynamic credential leases as a metric.
+func publishLeases(leases *leaseManager) {
+	expvar.Publish("leases", expvar.Func(leases.counts))
+}
+
+// serveMetrics exposes the daemon's metrics in expvar format at /debug/vars.
+// They are served on a mux of their own, so nothing else registered with
+// net/http's default one is exposed along with them.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/vars", metricsHandler)
+
+	log.Printf("Serving metrics on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logError("Error serving metrics: %+v", err)
+	}
+}
+
+// metricsHandler writes the published variables as a JSON object, as expvar's
+// own handler does, less those in unpublishedVars.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if unpublishedVars[kv.Key] {
+			return
+		}
+		if !first {
+			fmt.Fprint(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprint(w, "\n}\n")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/debug/vars", nil))
+
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("metrics are not JSON: %v\n%s", err, w.Body)
+	}
+	if _, ok := vars["vault_token_ttl_seconds"]; !ok {
+		t.Error("metrics have no vault_token_ttl_seconds")
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Error("metrics include the command line")
+	}
+}
+
+func TestLeaseCounts(t *testing.T) {
+	m := &leaseManager{
+		leases: map[string]*trackedLease{
+			"a.sock": {secret: &api.Secret{LeaseID: "database/creds/a/1", Renewable: true}},
+			"b.sock": {secret: &api.Secret{LeaseID: "database/creds/b/1"}},
+		},
+		superseded: map[string][]*trackedLease{
+			"a.sock": {{secret: &api.Secret{LeaseID: "database/creds/a/0"}}},
+		},
+	}
+
+	want := leaseCounts{Active: 2, Renewable: 1, Superseded: 1}
+	if got := m.counts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("counts() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+type NomadSecret struct {
+	Role string `yaml:"role"` // The Nomad role to generate tokens for
+}
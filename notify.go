@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// notifyReady tells systemd the daemon has started, for units of Type=notify,
+// so that units ordered after it don't race its sockets being created. It does
+// nothing when not run by systemd.
+func notifyReady(sockets int) {
+	sent, err := daemon.SdNotify(false, daemon.SdNotifyReady+"\nSTATUS="+fmt.Sprintf("Serving %d secrets", sockets))
+	if err != nil {
+		logError("Error notifying systemd of readiness: %+v", err)
+	} else if sent {
+		log.Print("Notified systemd of readiness")
+	}
+}
+
+// notifyReloading tells systemd the daemon is reloading its configuration, for
+// units of Type=notify-reload. Readiness is notified again once done.
+func notifyReloading() {
+	var now unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err != nil {
+		logError("Error reading monotonic clock: %+v", err)
+		return
+	}
+	state := fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d\nSTATUS=Reloading configuration", now.Nano()/1000)
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		logError("Error notifying systemd of reload: %+v", err)
+	}
+}
+
+// notifyStopping tells systemd the daemon is shutting down.
+func notifyStopping() {
+	if _, err := daemon.SdNotify(false, daemon.SdNotifyStopping); err != nil {
+		logError("Error notifying systemd of shutdown: %+v", err)
+	}
+}
+
+// prefetch fetches every secret once, so readiness implies they can be
+// served, or only the required secrets unless all. Failing to fetch a secret
+// marked optional is only logged.
+func (app *App) prefetch(ctx context.Context, all bool) error {
+	fetched := 0
+	for _, secret := range app.config.Secrets {
+		if !all && !secret.required() {
+			continue
+		}
+		if secret.WrapTTL != "" || (secret.Type == "transit" && secret.Transit != nil && secret.Transit.Operation != "") {
+			// Nothing to fetch ahead of a request, or nothing that can be fetched without using it up
+			continue
+		}
+		if _, err := app.fetchSecret(ctx, secret); err != nil {
+			err = errors.Wrapf(err, "fetching %s for %s", app.readPath(secret), secret.SocketPath)
+			if secret.optional() {
+				logError("Error fetching optional secret: %+v", err)
+				continue
+			}
+			return err
+		}
+		fetched++
+	}
+	if fetched > 0 {
+		log.Printf("Fetched %d secrets", fetched)
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+package main
+
+type OIDCSecret struct {
+	Role string `yaml:"role"` // The identity token role to sign tokens with
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// OpenBao serves Vault's API, less the parts that were Vault Enterprise's.
+// With the openbao setting, its BAO_* environment variables are read, health
+// is checked with only what OpenBao reports, and settings relying on Vault
+// Enterprise are refused rather than silently having no effect.
+
+// openBaoErrors returns the problems with settings cluster has that an
+// OpenBao server can't honour. Consistency relies on the X-Vault-Index and
+// forwarding headers of Vault Enterprise's performance standbys, which
+// OpenBao doesn't have.
+func openBaoErrors(cluster *Cluster) error {
+	if cluster.Consistency != "" {
+		return errors.Errorf("consistency %s needs Vault Enterprise, so can't be used with OpenBao", cluster.Consistency)
+	}
+	return nil
+}
+
+// readOpenBaoEnvironment configures the client from OpenBao's BAO_*
+// environment variables, which its CLI and agent use in place of VAULT_*.
+// Settings made explicitly, in the configuration or VAULT_* variables, are
+// kept.
+func readOpenBaoEnvironment(config *api.Config) error {
+	if v := os.Getenv("BAO_ADDR"); v != "" && os.Getenv(api.EnvVaultAddress) == "" {
+		config.Address = v
+	}
+
+	tlsConfig := &api.TLSConfig{
+		CACert:        os.Getenv("BAO_CACERT"),
+		CAPath:        os.Getenv("BAO_CAPATH"),
+		ClientCert:    os.Getenv("BAO_CLIENT_CERT"),
+		ClientKey:     os.Getenv("BAO_CLIENT_KEY"),
+		TLSServerName: os.Getenv("BAO_TLS_SERVER_NAME"),
+	}
+	if v := os.Getenv("BAO_SKIP_VERIFY"); v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrap(err, "parsing BAO_SKIP_VERIFY")
+		}
+		tlsConfig.Insecure = insecure
+	}
+	return errors.Wrap(config.ConfigureTLS(tlsConfig), "configuring TLS from BAO_* environment")
+}
+
+// openBaoClientDefaults applies the token and namespace from OpenBao's
+// environment variables, unless the client already has them.
+func openBaoClientDefaults(client *api.Client) {
+	if v := os.Getenv("BAO_TOKEN"); v != "" && client.Token() == "" {
+		client.SetToken(v)
+	}
+	if v := os.Getenv("BAO_NAMESPACE"); v != "" && os.Getenv(api.EnvVaultNamespace) == "" {
+		client.SetNamespace(v)
+	}
+}
+
+// openBaoHealth is the health of an OpenBao server, as reported by sys/health.
+type openBaoHealth struct {
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	Standby     bool   `json:"standby"`
+	Version     string `json:"version"`
+}
+
+// readOpenBaoHealth reads the health of an OpenBao server. Vault's client
+// asks for status codes for replication and performance standbys, which
+// OpenBao has neither of; only the codes OpenBao has are overridden here, so
+// every state it reports is read rather than turned into an error.
+func readOpenBaoHealth(ctx context.Context, client *api.Client) (*openBaoHealth, error) {
+	r := client.NewRequest(http.MethodGet, "/v1/sys/health")
+	r.Params.Add("uninitcode", "299")
+	r.Params.Add("sealedcode", "299")
+	r.Params.Add("standbycode", "299")
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	var health openBaoHealth
+	if err = resp.DecodeJSON(&health); err != nil {
+		return nil, errors.Wrap(err, "decoding OpenBao health")
+	}
+	return &health, nil
+}
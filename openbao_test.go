@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestReadOpenBaoEnvironment(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		address  string
+		insecure bool
+		wantErr  bool
+	}{
+		{
+			name:    "BAO_ADDR",
+			env:     map[string]string{"BAO_ADDR": "https://bao.example:8200"},
+			address: "https://bao.example:8200",
+		},
+		{
+			name:    "VAULT_ADDR wins",
+			env:     map[string]string{"BAO_ADDR": "https://bao.example:8200", "VAULT_ADDR": "https://vault.example:8200"},
+			address: "https://vault.example:8200",
+		},
+		{
+			name:     "BAO_SKIP_VERIFY",
+			env:      map[string]string{"BAO_ADDR": "https://bao.example:8200", "BAO_SKIP_VERIFY": "true"},
+			address:  "https://bao.example:8200",
+			insecure: true,
+		},
+		{
+			name:    "invalid BAO_SKIP_VERIFY",
+			env:     map[string]string{"BAO_SKIP_VERIFY": "sometimes"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"BAO_ADDR", "BAO_SKIP_VERIFY", "VAULT_ADDR", "VAULT_SKIP_VERIFY"} {
+				t.Setenv(name, tt.env[name])
+			}
+			config := api.DefaultConfig()
+			err := readOpenBaoEnvironment(config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readOpenBaoEnvironment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if config.Address != tt.address {
+				t.Errorf("Address = %q, want %q", config.Address, tt.address)
+			}
+			insecure := config.HttpClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify
+			if insecure != tt.insecure {
+				t.Errorf("InsecureSkipVerify = %v, want %v", insecure, tt.insecure)
+			}
+		})
+	}
+}
+
+func TestOpenBaoClientDefaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		token     string // The token the client already has
+		wantToken string
+		wantNS    string
+	}{
+		{
+			name:      "BAO_TOKEN and BAO_NAMESPACE",
+			env:       map[string]string{"BAO_TOKEN": "s.bao", "BAO_NAMESPACE": "team"},
+			wantToken: "s.bao",
+			wantNS:    "team",
+		},
+		{
+			name:      "token already set",
+			env:       map[string]string{"BAO_TOKEN": "s.bao"},
+			token:     "s.vault",
+			wantToken: "s.vault",
+		},
+		{
+			name:   "VAULT_NAMESPACE wins",
+			env:    map[string]string{"BAO_NAMESPACE": "team", "VAULT_NAMESPACE": "other"},
+			wantNS: "other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"BAO_TOKEN", "BAO_NAMESPACE", "VAULT_TOKEN", "VAULT_NAMESPACE"} {
+				t.Setenv(name, tt.env[name])
+			}
+			client, err := api.NewClient(api.DefaultConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.SetToken(tt.token)
+			openBaoClientDefaults(client)
+			if client.Token() != tt.wantToken {
+				t.Errorf("Token() = %q, want %q", client.Token(), tt.wantToken)
+			}
+			if ns := client.Headers().Get("X-Vault-Namespace"); ns != tt.wantNS {
+				t.Errorf("namespace = %q, want %q", ns, tt.wantNS)
+			}
+		})
+	}
+}
+
+func TestOpenBaoErrors(t *testing.T) {
+	if err := openBaoErrors(&Cluster{OpenBao: true}); err != nil {
+		t.Errorf("openBaoErrors() error = %v", err)
+	}
+	if err := openBaoErrors(&Cluster{OpenBao: true, Consistency: "read_your_writes"}); err == nil {
+		t.Error("openBaoErrors() allowed consistency")
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	tests := []struct {
+		name    string
+		openBao bool
+		body    string
+		wantErr string
+	}{
+		{
+			name:    "openbao",
+			openBao: true,
+			body:    `{"initialized":true,"sealed":false,"standby":false,"server_time_utc":1700000000,"version":"2.0.2","cluster_name":"bao","cluster_id":"1"}`,
+		},
+		{
+			name:    "openbao standby",
+			openBao: true,
+			body:    `{"initialized":true,"sealed":false,"standby":true,"version":"2.0.2"}`,
+		},
+		{
+			name:    "openbao sealed",
+			openBao: true,
+			body:    `{"initialized":true,"sealed":true,"standby":true,"version":"2.0.2"}`,
+			wantErr: "OpenBao is sealed",
+		},
+		{
+			name:    "openbao not initialized",
+			openBao: true,
+			body:    `{"initialized":false,"sealed":true,"standby":true,"version":"2.0.2"}`,
+			wantErr: "OpenBao is not initialized",
+		},
+		{
+			name: "vault",
+			body: `{"initialized":true,"sealed":false,"standby":false,"performance_standby":false,"replication_performance_mode":"disabled","replication_dr_mode":"disabled","version":"1.15.0"}`,
+		},
+		{
+			name:    "vault sealed",
+			body:    `{"initialized":true,"sealed":true,"standby":true,"version":"1.15.0"}`,
+			wantErr: "Vault is sealed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/sys/health" {
+					http.NotFound(w, r)
+					return
+				}
+				// OpenBao has no replication or performance standbys to
+				// set the codes of
+				if tt.openBao && (r.URL.Query().Has("drsecondarycode") || r.URL.Query().Has("performancestandbycode")) {
+					t.Errorf("OpenBao health requested with %s", r.URL.RawQuery)
+				}
+				if r.URL.Query().Get("sealedcode") != "299" || r.URL.Query().Get("standbycode") != "299" {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			config := api.DefaultConfig()
+			config.Address = server.URL
+			client, err := api.NewClient(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = serverHealth(context.Background(), client, &Cluster{OpenBao: tt.openBao})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("serverHealth() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("serverHealth() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestOpenBaoServer fetches a secret from a real OpenBao server, as started
+// with:
+//
+//	docker run --rm -p 8200:8200 -e BAO_DEV_ROOT_TOKEN_ID=root openbao/openbao server -dev
+//	OPENBAO_TEST_ADDR=http://127.0.0.1:8200 OPENBAO_TEST_TOKEN=root go test -run OpenBaoServer
+func TestOpenBaoServer(t *testing.T) {
+	addr, token := os.Getenv("OPENBAO_TEST_ADDR"), os.Getenv("OPENBAO_TEST_TOKEN")
+	if addr == "" || token == "" {
+		t.Skip("OPENBAO_TEST_ADDR and OPENBAO_TEST_TOKEN not set")
+	}
+	for _, name := range []string{"VAULT_ADDR", "VAULT_TOKEN", "VAULT_NAMESPACE", "BAO_ADDR", "BAO_NAMESPACE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("BAO_ADDR", addr)
+	t.Setenv("BAO_TOKEN", token)
+
+	ctx := context.Background()
+	app := newApp(&Config{OpenBao: true, VaultMount: "secret", KVVersion: 2})
+	if err := setupVault(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if err := serverHealth(ctx, app.client, app.config.defaultCluster()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.client.Logical().WriteWithContext(ctx, "secret/data/scv-test", map[string]interface{}{
+		"data": map[string]interface{}{"password": "hunter2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	value, err := app.fetchSecret(ctx, Secret{VaultPath: "scv-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.Data["password"] != "hunter2" {
+		t.Errorf("fetchSecret() = %v, want password hunter2", value.Data)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// requestData returns the body of a write made for secret: data, with the
+// secret's params added over it.
+func requestData(secret Secret, data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	for k, v := range secret.Params {
+		data[k] = v
+	}
+	return data
+}
+
+// requestQuery returns the parameters of a read made for secret: query, with
+// the secret's params added over it. Lists become repeated parameters.
+func requestQuery(secret Secret, query url.Values) url.Values {
+	if len(secret.Params) == 0 {
+		return query
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	for k, v := range secret.Params {
+		query.Del(k)
+		if list, ok := v.([]interface{}); ok {
+			for _, item := range list {
+				query.Add(k, fmt.Sprint(item))
+			}
+			continue
+		}
+		query.Set(k, fmt.Sprint(v))
+	}
+	return query
+}
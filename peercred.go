@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// peerCred identifies the process at the other end of a connection.
+type peerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// authorize checks that the process connected on c may be served secret.
+// unit is the systemd unit the connection is made for, if already verified,
+// as it is for systemd loading a credential; otherwise the unit is resolved
+// from the cgroup of the process.
+func (app *App) authorize(secret Secret, c net.Conn, unit string) error {
+	if err := authorizeGuest(secret, c); err != nil {
+		return err
+	}
+	if !secret.PID1Only && len(secret.AllowedUIDs) == 0 && len(secret.AllowedGIDs) == 0 && len(secret.AllowedUnits) == 0 {
+		return nil
+	}
+
+	cred, err := peerCredentials(c)
+	if err != nil {
+		return errors.Wrap(err, "reading peer credentials")
+	}
+
+	if secret.PID1Only && cred.PID != managerPID() {
+		return errors.Errorf("process %d is not systemd", cred.PID)
+	}
+
+	if len(secret.AllowedUIDs) > 0 || len(secret.AllowedGIDs) > 0 {
+		if !containsID(secret.AllowedUIDs, cred.UID) && !containsID(secret.AllowedGIDs, cred.GID) {
+			return errors.Errorf("process %d (uid %d, gid %d) is not allowed", cred.PID, cred.UID, cred.GID)
+		}
+	}
+
+	if len(secret.AllowedUnits) > 0 {
+		if unit == "" {
+			if unit, err = processUnit(cred.PID); err != nil {
+				return errors.Wrapf(err, "resolving unit of process %d", cred.PID)
+			}
+		}
+		for _, allowed := range secret.AllowedUnits {
+			if unit == allowed {
+				return nil
+			}
+		}
+		return errors.Errorf("process %d in unit %s is not allowed", cred.PID, unit)
+	}
+
+	return nil
+}
+
+func containsID(ids []uint32, id uint32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// unitSuffixes are the types of systemd unit a process can run in.
+var unitSuffixes = []string{".service", ".scope"}
+
+// processUnit returns the systemd unit a process runs in, from its cgroup:
+// the outermost service or scope in its path in the unified hierarchy, or in
+// the name=systemd hierarchy under cgroup v1. Units below that one are
+// managed by the unit itself, as a user's service manager or a service with
+// Delegate=yes does, and can be named anything, so are never trusted.
+func processUnit(pid int32) (string, error) {
+	f, err := os.Open("/proc/" + strconv.Itoa(int(pid)) + "/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return cgroupUnit(f)
+}
+
+// cgroupUnit returns the systemd unit named in r, the contents of a process's
+// /proc/<pid>/cgroup.
+func cgroupUnit(r io.Reader) (string, error) {
+	var cgroup string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if (parts[0] == "0" && parts[1] == "") || parts[1] == "name=systemd" {
+			cgroup = parts[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	for _, name := range strings.Split(path.Clean(cgroup), "/") {
+		for _, suffix := range unitSuffixes {
+			if strings.HasSuffix(name, suffix) {
+				return name, nil
+			}
+		}
+	}
+	return "", errors.Errorf("no unit found in cgroup %q", cgroup)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// peerCredentials reads SO_PEERCRED from a unix socket connection.
+func peerCredentials(c net.Conn) (*peerCred, error) {
+	unixConn, ok := c.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return &peerCred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// peerCredentials is only supported on Linux.
+func peerCredentials(c net.Conn) (*peerCred, error) {
+	return nil, errors.New("peer credentials are only supported on Linux")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCgroupUnit(t *testing.T) {
+	tests := []struct {
+		name    string
+		cgroup  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "unified",
+			cgroup: "0::/system.slice/postgresql.service\n",
+			want:   "postgresql.service",
+		},
+		{
+			name:   "nested cgroup of a service",
+			cgroup: "0::/system.slice/app.service/workers/1\n",
+			want:   "app.service",
+		},
+		{
+			name:   "unit of a user's service manager",
+			cgroup: "0::/user.slice/user-1000.slice/user@1000.service/app.slice/postgresql.service\n",
+			want:   "user@1000.service",
+		},
+		{
+			name:   "delegated subtree",
+			cgroup: "0::/system.slice/containers.service/payload/system.slice/postgresql.service\n",
+			want:   "containers.service",
+		},
+		{
+			name:   "scope",
+			cgroup: "0::/user.slice/user-1000.slice/session-2.scope\n",
+			want:   "session-2.scope",
+		},
+		{
+			name:   "template instance",
+			cgroup: "0::/system.slice/system-app.slice/app@blue.service\n",
+			want:   "app@blue.service",
+		},
+		{
+			name:   "cgroup v1",
+			cgroup: "12:pids:/system.slice/app.service\n11:memory:/\n1:name=systemd:/system.slice/app.service\n",
+			want:   "app.service",
+		},
+		{
+			name:   "hybrid",
+			cgroup: "12:pids:/\n1:name=systemd:/system.slice/app.service\n0::/system.slice/app.service\n",
+			want:   "app.service",
+		},
+		{
+			name:   "init",
+			cgroup: "0::/init.scope\n",
+			want:   "init.scope",
+		},
+		{
+			name:    "no unit",
+			cgroup:  "0::/system.slice\n",
+			wantErr: true,
+		},
+		{
+			name:    "root",
+			cgroup:  "0::/\n",
+			wantErr: true,
+		},
+		{
+			name:    "controllers only",
+			cgroup:  "12:pids:/system.slice/app.service\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cgroupUnit(strings.NewReader(tt.cgroup))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cgroupUnit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("cgroupUnit() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// defaultSocketMode is the mode of sockets without one configured.
+const defaultSocketMode = 0700
+
+// applySocketPermissions sets the owner, group and mode configured for secret
+// on its socket at path, so that services running as other users can connect.
+func (app *App) applySocketPermissions(secret Secret, path string) error {
+	uid, gid := -1, -1
+	var err error
+	if secret.Owner != "" {
+		if uid, err = lookupUID(secret.Owner); err != nil {
+			return err
+		}
+	}
+	if secret.Group != "" {
+		if gid, err = lookupGID(secret.Group); err != nil {
+			return err
+		}
+	}
+	if uid != -1 || gid != -1 {
+		if err = os.Chown(path, uid, gid); err != nil {
+			return errors.Wrapf(err, "changing ownership of %s", path)
+		}
+	}
+
+	mode := os.FileMode(defaultSocketMode)
+	for _, configured := range []string{app.config.SocketMode, secret.Mode} {
+		if configured == "" {
+			continue
+		}
+		if mode, err = parseMode(configured); err != nil {
+			return errors.Wrapf(err, "socket %s", path)
+		}
+	}
+	if err = os.Chmod(path, mode); err != nil {
+		return errors.Wrapf(err, "changing mode of %s", path)
+	}
+	return nil
+}
+
+// defaultSocketDirMode is the mode of directories created to hold sockets.
+const defaultSocketDirMode = 0755
+
+// createSocketDir creates any missing directories the socket at path is
+// created in, such as those grouping a service's credentials.
+func (app *App) createSocketDir(path string) error {
+	mode := os.FileMode(defaultSocketDirMode)
+	if app.config.SocketDirMode != "" {
+		var err error
+		if mode, err = parseMode(app.config.SocketDirMode); err != nil {
+			return errors.Wrap(err, "socket_dir_mode")
+		}
+	}
+
+	var missing []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), mode); err != nil {
+		return errors.Wrap(err, "creating socket directory")
+	}
+	// MkdirAll's mode is masked by the umask
+	for _, dir := range missing {
+		if err := os.Chmod(dir, mode); err != nil {
+			return errors.Wrapf(err, "changing mode of %s", dir)
+		}
+	}
+	return nil
+}
+
+// parseMode parses octal file permissions, such as "0750".
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.Errorf("invalid mode %q", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// lookupUID returns the ID of a user given by name or number.
+func lookupUID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, errors.Wrapf(err, "looking up user %s", name)
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGID returns the ID of a group given by name or number.
+func lookupGID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, errors.Wrapf(err, "looking up group %s", name)
+	}
+	return strconv.Atoi(g.Gid)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type PKISecret struct {
+	Role       string   `yaml:"role"`        // The PKI role to issue the certificate with
+	CommonName string   `yaml:"common_name"` // The certificate's common name
+	AltNames   []string `yaml:"alt_names"`   // Additional DNS or email subject alternative names (optional)
+	TTL        string   `yaml:"ttl"`         // The requested certificate lifetime, e.g. 72h (optional, defaults to the role's TTL)
+
+	RenewFraction float64 `yaml:"renew_fraction"` // The fraction of a certificate's lifetime after which a new one is issued (default: 0.66)
+}
+
+const defaultPKIRenewFraction = 0.66
+
+// certCache holds issued certificates by socket path, so they can be served
+// again until they are due for renewal. The lock guards certs only, and isn't
+// held while certificates are issued.
+type certCache struct {
+	sync.Mutex
+	certs   map[string]*cachedCert
+	issuing keyLocks // Serialises issuing each socket path's certificate
+}
+
+type cachedCert struct {
+	value   *secretValue
+	renewAt time.Time
+}
+
+// certificate returns the cached certificate for a pki secret, issuing a new
+// one if there is none or it has passed its renewal point.
+func (app *App) certificate(ctx context.Context, secret Secret) (*secretValue, error) {
+	defer app.certs.issuing.lock(secret.SocketPath)()
+
+	app.certs.Lock()
+	cached, ok := app.certs.certs[secret.SocketPath]
+	app.certs.Unlock()
+	if ok && time.Now().Before(cached.renewAt) {
+		return cached.value, nil
+	}
+
+	value, err := app.issueCertificate(ctx, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	expiration, err := dataTime(value.Data, "expiration")
+	if err != nil {
+		// Without knowing when it expires, the certificate can't safely be reused
+		logWarning("Not caching certificate for %s: %+v", secret.SocketPath, err)
+		return value, nil
+	}
+
+	fraction := secret.PKI.RenewFraction
+	if fraction <= 0 || fraction > 1 {
+		fraction = defaultPKIRenewFraction
+	}
+	now := time.Now()
+	renewAt := now.Add(time.Duration(float64(expiration.Sub(now)) * fraction))
+
+	app.certs.Lock()
+	if app.certs.certs == nil {
+		app.certs.certs = map[string]*cachedCert{}
+	}
+	app.certs.certs[secret.SocketPath] = &cachedCert{value: value, renewAt: renewAt}
+	app.certs.Unlock()
+	log.Printf("Issued certificate for %s, renewing after %s", secret.SocketPath, renewAt.Format(time.RFC3339))
+
+	return value, nil
+}
+
+// issueCertificate issues a new certificate for a pki secret. The default
+// rendering is a PEM bundle of the private key, certificate and CA chain.
+func (app *App) issueCertificate(ctx context.Context, secret Secret) (*secretValue, error) {
+	if secret.PKI == nil || secret.PKI.Role == "" || secret.PKI.CommonName == "" {
+		return nil, errors.New("pki secrets require a pki role and common_name")
+	}
+
+	data := map[string]interface{}{
+		"common_name": secret.PKI.CommonName,
+	}
+	if len(secret.PKI.AltNames) > 0 {
+		data["alt_names"] = strings.Join(secret.PKI.AltNames, ",")
+	}
+	if secret.PKI.TTL != "" {
+		data["ttl"] = secret.PKI.TTL
+	}
+
+	path := app.readPath(secret)
+	s, err := app.clientFor(secret).Logical().WriteWithContext(ctx, path, requestData(secret, data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "issuing certificate from %s", path)
+	}
+	if s == nil {
+		return nil, errors.Errorf("no certificate returned from %s", path)
+	}
+
+	return &secretValue{Data: s.Data, Default: pemBundle(s.Data)}, nil
+}
+
+// dataTime reads a Unix timestamp field from a Vault response.
+func dataTime(data map[string]interface{}, field string) (time.Time, error) {
+	var seconds int64
+	switch v := data[field].(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "parsing %s", field)
+		}
+		seconds = n
+	case float64:
+		seconds = int64(v)
+	default:
+		return time.Time{}, errors.Errorf("response has no %s", field)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// pemBundle concatenates the private key, certificate and CA chain of a PKI
+// response, in the order expected by most TLS servers.
+func pemBundle(data map[string]interface{}) []byte {
+	parts := []string{}
+	for _, field := range []string{"private_key", "certificate"} {
+		if pem, ok := data[field].(string); ok {
+			parts = append(parts, pem)
+		}
+	}
+
+	if chain, ok := data["ca_chain"].([]interface{}); ok && len(chain) > 0 {
+		for _, ca := range chain {
+			if pem, ok := ca.(string); ok {
+				parts = append(parts, pem)
+			}
+		}
+	} else if ca, ok := data["issuing_ca"].(string); ok {
+		parts = append(parts, ca)
+	}
+
+	return []byte(strings.Join(parts, "\n") + "\n")
+}
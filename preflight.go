@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checkCapabilities verifies that the Vault token can fetch every configured
+// secret, so ACL problems surface at startup rather than when a consumer
+// first connects. Depending on the preflight setting, missing capabilities,
+// and capabilities that can't be checked, are either logged or returned as an
+// error.
+func (app *App) checkCapabilities(ctx context.Context) error {
+	if app.config.Preflight == "off" {
+		return nil
+	}
+
+	var denied []string
+	for _, secret := range app.config.Secrets {
+		path := app.readPath(secret)
+
+		capabilities, err := app.clientFor(secret).Sys().CapabilitiesSelfWithContext(ctx, path)
+		if err != nil {
+			if app.config.Preflight == "fail" {
+				return errors.Wrapf(err, "checking capabilities on %s", path)
+			}
+			logWarning("Error checking capabilities on %s: %v", path, err)
+			continue
+		}
+
+		if want := fetchCapability(secret); !hasCapability(capabilities, want) {
+			logWarning("Vault token lacks %s capability on %s (capabilities: %s)", want, path, strings.Join(capabilities, ", "))
+			denied = append(denied, path)
+		}
+	}
+
+	if len(denied) > 0 && app.config.Preflight == "fail" {
+		return errors.Errorf("Vault token lacks capabilities on %s", strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+func hasCapability(capabilities []string, want string) bool {
+	for _, c := range capabilities {
+		if c == want || c == "root" {
+			return true
+		}
+	}
+	return false
+}
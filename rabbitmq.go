@@ -0,0 +1,5 @@
+package main
+
+type RabbitMQSecret struct {
+	Role string `yaml:"role"` // The RabbitMQ role to generate credentials for
+}
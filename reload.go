@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-yaml/yaml"
+)
+
+// flushCaches forgets the cached certificates and credentials of the secrets
+// with the given socket paths, or of every secret if none are given, so that
+// they are fetched from Vault afresh when next served. Leases are not revoked,
+// as their credentials may still be in use: they are kept renewed until their
+// maximum TTL once superseded.
+func (app *App) flushCaches(keys ...string) {
+	flush := func(key string) bool {
+		if len(keys) == 0 {
+			return true
+		}
+		for _, k := range keys {
+			if k == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	app.certs.Lock()
+	for key := range app.certs.certs {
+		if flush(key) {
+			delete(app.certs.certs, key)
+		}
+	}
+	app.certs.Unlock()
+
+	app.staticCreds.Lock()
+	for key := range app.staticCreds.creds {
+		if flush(key) {
+			delete(app.staticCreds.creds, key)
+		}
+	}
+	app.staticCreds.Unlock()
+
+	app.leases.Lock()
+	for key, tracked := range app.leases.leases {
+		if flush(key) {
+			tracked.reissue = time.Now()
+		}
+	}
+	app.leases.Unlock()
+}
+
+// reloadConfig re-reads the configuration file, and fetches every secret
+// afresh, reporting the reload to systemd for units of Type=notify-reload.
+// Sockets are started for added secrets and stopped for removed ones, while
+// the others are left serving throughout. A configuration that can't be read
+// is logged, and the running one kept. Only secrets are reloaded: changes to
+// other settings are logged as needing a restart.
+func (app *App) reloadConfig(ctx context.Context) {
+	app.reloading.Lock()
+	defer app.reloading.Unlock()
+
+	notifyReloading()
+	defer func() { notifyReady(len(app.servedSecrets())) }()
+
+	log.Printf("Reloading configuration from %s", app.configPath)
+	config, err := newConfig(app.configPath, app.configDir)
+	if err != nil {
+		logError("Error reloading configuration, keeping the running one: %+v", err)
+		return
+	}
+	secrets := config.Secrets
+	if config.SecretsFrom != nil {
+		remote, err := app.remoteSecrets(ctx, config)
+		if err != nil {
+			logError("Error reloading remote secrets, keeping the running configuration: %+v", err)
+			return
+		}
+		secrets = append(secrets, remote...)
+	}
+	if changed := restartSettings(app.config, config); len(changed) > 0 {
+		logWarning("Settings changed which need a restart to apply: %s", strings.Join(changed, ", "))
+	}
+	warnUnwatchedRotations(config)
+	app.applySecrets(ctx, secrets)
+	app.reload()
+}
+
+// restartSettings returns the settings besides secrets which differ between
+// the running configuration and config, as reloading doesn't apply them.
+func restartSettings(running, config *Config) []string {
+	var changed []string
+	t := reflect.TypeOf(*config)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if t.Field(i).PkgPath != "" || name == "" || name == "-" || name == "secrets" || name == "include" {
+			continue
+		}
+		// Compared as configured, ignoring any state kept alongside
+		was, _ := yaml.Marshal(reflect.ValueOf(*running).Field(i).Interface())
+		now, _ := yaml.Marshal(reflect.ValueOf(*config).Field(i).Interface())
+		if !bytes.Equal(was, now) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// configReloadDelay is how long the configuration file must be left alone
+// before changes to it are applied, so that a file written in several steps
+// is reloaded once and complete.
+const configReloadDelay = 2 * time.Second
+
+// watchConfig reloads the configuration whenever its file, or a file in its
+// directory, changes, until ctx is cancelled.
+func (app *App) watchConfig(ctx context.Context) error {
+	changed, err := watchFile(ctx, app.configPath)
+	if err != nil {
+		return err
+	}
+	log.Printf("Watching %s for changes", app.configPath)
+	var dirChanged <-chan struct{}
+	if app.configDir != "" {
+		if dirChanged, err = watchDir(ctx, app.configDir); err != nil {
+			return err
+		}
+		log.Printf("Watching %s for changes", app.configDir)
+	}
+
+	go func() {
+		var settled <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				settled = time.After(configReloadDelay)
+			case <-dirChanged:
+				settled = time.After(configReloadDelay)
+			case <-settled:
+				settled = nil
+				log.Print("Configuration changed")
+				app.reloadConfig(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+// applySecrets serves secrets in place of the secrets being served, leaving
+// those that are unchanged alone. Sockets passed by systemd can't be
+// recreated, so changes to those need a restart.
+func (app *App) applySecrets(ctx context.Context, secrets []Secret) {
+	running := map[string]Secret{}
+	for _, secret := range app.servedSecrets() {
+		running[secret.SocketPath] = secret
+	}
+	wanted := map[string]bool{}
+	for _, secret := range secrets {
+		wanted[secret.SocketPath] = true
+	}
+
+	for _, secret := range app.servedSecrets() {
+		if wanted[secret.SocketPath] {
+			continue
+		}
+		if _, ok := app.activatedListener(secret); ok {
+			logWarning("Secret %s removed, but its socket was passed by systemd; restart to stop serving it", secret.SocketPath)
+			continue
+		}
+		app.stopSecret(secret)
+	}
+
+	for _, secret := range secrets {
+		old, ok := running[secret.SocketPath]
+		if ok && reflect.DeepEqual(old, secret) || app.served.wasConsumed(secret) {
+			continue
+		}
+		if err := app.checkClient(secret); err != nil {
+			logError("Error reloading secret %s, keeping the running configuration: %+v", secret.SocketPath, err)
+			continue
+		}
+		if (secret.Type == "" || secret.Type == "kv") && secret.KVVersion == 0 && app.config.KVVersion == 0 {
+			app.detectMountVersion(ctx, secret)
+		}
+		if secret.required() {
+			if _, err := app.fetchSecret(ctx, secret); err != nil {
+				logError("Error fetching required secret %s, keeping the running configuration: %+v", secret.SocketPath, err)
+				continue
+			}
+		}
+		if ok {
+			if _, activated := app.activatedListener(secret); activated {
+				logWarning("Secret %s changed, but its socket was passed by systemd; restart to apply the change", secret.SocketPath)
+				continue
+			}
+			app.stopSecret(old)
+		}
+		if err := app.serveSecret(ctx, secret); err != nil {
+			logError("Error serving secret %s: %+v", secret.SocketPath, err)
+			continue
+		}
+		if ok {
+			log.Printf("Updated secret %s", secret.SocketPath)
+		} else {
+			log.Printf("Added secret %s", secret.SocketPath)
+		}
+	}
+}
+
+// reload fetches every secret afresh: caches are flushed, and files of
+// secrets are rewritten if they have changed.
+func (app *App) reload() {
+	app.flushCaches()
+	log.Print("Flushed cached secrets")
+	for _, refresh := range []chan struct{}{app.filesRotated, app.credStoreRotated()} {
+		signalRefresh(refresh)
+	}
+}
+
+// refreshFiles has the file secret is maintained in rewritten, reporting
+// whether it is maintained in one.
+func (app *App) refreshFiles(secret Secret) bool {
+	switch {
+	case strings.HasPrefix(secret.SocketPath, "credstore#"):
+		signalRefresh(app.credStoreRotated())
+	case strings.HasPrefix(secret.SocketPath, "file#"):
+		signalRefresh(app.filesRotated)
+	default:
+		return false
+	}
+	return true
+}
+
+// signalRefresh signals refresh without waiting, as one pending signal is as
+// good as several.
+func signalRefresh(refresh chan struct{}) {
+	select {
+	case refresh <- struct{}{}:
+	default:
+	}
+}
+
+// credStoreRotated returns the channel signalling the credstore to refresh,
+// or nil if there isn't one.
+func (app *App) credStoreRotated() chan struct{} {
+	if app.config.CredStore == nil {
+		return nil
+	}
+	return app.config.CredStore.rotated
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/pkg/errors"
+)
+
+type RemoteSecrets struct {
+	VaultPath string `yaml:"vault_path"` // The KV secret holding the secrets list
+	Mount     string `yaml:"mount"`      // The KV mount to read it from (optional, defaults to vault_mount)
+	Field     string `yaml:"field"`      // The field holding the list, as YAML or JSON (default: secrets)
+	Identity  string `yaml:"identity"`   // The named identity to read it with (optional, defaults to auth)
+}
+
+// loadRemoteSecrets reads the secrets list stored in Vault, if one is
+// configured, adding it to the secrets configured locally. Fleet-wide
+// mappings can thereby be managed centrally.
+func (app *App) loadRemoteSecrets(ctx context.Context) error {
+	if app.config.SecretsFrom == nil {
+		return nil
+	}
+	secrets, err := app.remoteSecrets(ctx, app.config)
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		if err = app.checkClient(secret); err != nil {
+			return err
+		}
+	}
+	app.config.Secrets = append(app.config.Secrets, secrets...)
+	return nil
+}
+
+// remoteRestricted are the settings secrets from Vault may not have. Anyone
+// able to write the secrets list could otherwise run commands, control units,
+// or open up sockets on every host reading it.
+var remoteRestricted = []string{"on_rotate", "restart_units", "reload_units", "units", "owner", "group", "mode", "file_path", "vault"}
+
+// remoteErrors returns the problems with a secret from Vault: settings it may
+// not have, and socket paths outside socket_root.
+func remoteErrors(at string, secret Secret) []string {
+	var problems []string
+	value := reflect.ValueOf(secret)
+	for _, key := range remoteRestricted {
+		if field, ok := yamlField(value.Type(), key); ok && !value.FieldByIndex(field.Index).IsZero() {
+			problems = append(problems, at+": "+key+" can't be set by secrets from Vault")
+		}
+	}
+	return append(problems, socketPathErrors(at, secret)...)
+}
+
+// socketPathErrors returns the problems with socket paths taken from Vault,
+// which must stay within socket_root.
+func socketPathErrors(at string, secret Secret) []string {
+	var problems []string
+	for _, socketPath := range append([]string{secret.SocketPath}, secret.Aliases...) {
+		if socketPath == "" || strings.HasPrefix(socketPath, "@") {
+			continue
+		}
+		clean := filepath.Clean(socketPath)
+		if clean != socketPath || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			problems = append(problems, at+": socket path "+socketPath+" must be a clean path within socket_root")
+		}
+	}
+	return problems
+}
+
+// localAddresses returns the addresses served by the secrets configured
+// locally, which secrets from Vault mustn't take over.
+func (config *Config) localAddresses() map[string]string {
+	addresses := map[string]string{}
+	for _, secret := range config.Secrets {
+		address := secret.SocketPath
+		if secret.VSockPort != 0 {
+			address = fmt.Sprintf("vsock port %d", secret.VSockPort)
+		}
+		if address != "" {
+			addresses[address] = "a local secret"
+		}
+	}
+	if cs := config.CredentialSocket; cs != nil && cs.SocketPath != "" {
+		addresses[cs.SocketPath] = "credential_socket"
+	}
+	return addresses
+}
+
+// remoteSecrets reads the secrets list stored in Vault for config, with its
+// defaults.
+func (app *App) remoteSecrets(ctx context.Context, config *Config) ([]Secret, error) {
+	remote := config.SecretsFrom
+	source := Secret{Type: "kv", VaultPath: remote.VaultPath, Mount: remote.Mount, Identity: remote.Identity}
+	if err := app.checkClient(source); err != nil {
+		return nil, err
+	}
+	if app.config.KVVersion == 0 {
+		app.detectMountVersion(ctx, source)
+	}
+
+	kv, err := app.fetchKV(ctx, source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading secrets list from %s", remote.VaultPath)
+	}
+
+	field := remote.Field
+	if field == "" {
+		field = "secrets"
+	}
+	var content []byte
+	switch v := kv.Data[field].(type) {
+	case nil:
+		return nil, errors.Errorf("secret %s has no field %q", remote.VaultPath, field)
+	case string:
+		content = []byte(v)
+	default:
+		// Stored as JSON rather than text; YAML is a superset of it
+		if content, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	var secrets []Secret
+	if err = yaml.UnmarshalStrict(content, &secrets); err != nil {
+		return nil, errors.Wrapf(err, "parsing secrets list from %s", remote.VaultPath)
+	}
+	at := entryLocator(remote.VaultPath, entryLines(content, ""), "secrets")
+	problems := validateSecrets(secrets, at, config.localAddresses())
+	for i, secret := range secrets {
+		problems = append(problems, remoteErrors(at(i), secret)...)
+	}
+	if len(problems) > 0 {
+		return nil, errors.Wrapf(configErrors(problems), "validating secrets list from %s", remote.VaultPath)
+	}
+	log.Printf("Loaded %d secrets from %s", len(secrets), remote.VaultPath)
+	secrets = config.defaulted(secrets)
+	for i := range secrets {
+		secrets[i].Cluster = config.clusterName(secrets[i].Cluster)
+	}
+	return metadataSockets(aliasSockets(secrets)), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// renderSecret produces the credential served for secret, with its metadata
+// if configured.
+func renderSecret(secret Secret, value *secretValue) ([]byte, error) {
+	if secret.Metadata == "" {
+		return renderContent(secret, value)
+	}
+	if secret.metadataOnly {
+		return renderMetadata(secret, value, nil)
+	}
+
+	content, err := renderContent(secret, value)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Metadata != "json" {
+		return content, nil
+	}
+	return renderMetadata(secret, value, content)
+}
+
+// renderContent renders secret's value: its template if one is configured, a
+// single field if one is configured, otherwise all fields in the configured
+// format.
+func renderContent(secret Secret, value *secretValue) ([]byte, error) {
+	if secret.Template != "" {
+		return renderTemplate(secret.Template, value.Data)
+	}
+
+	if secret.Field != "" {
+		field, ok := value.Data[secret.Field]
+		if !ok {
+			return nil, errors.Errorf("secret has no field %q", secret.Field)
+		}
+		return renderValue(field)
+	}
+
+	if rendered, ok := value.Formats[secret.Format]; ok {
+		return rendered, nil
+	}
+
+	switch secret.Format {
+	case "":
+		return value.Default, nil
+	case "json":
+		return json.Marshal(value.Data)
+	case "env":
+		return renderEnv(value.Data)
+	default:
+		return nil, errors.Errorf("unsupported format %q", secret.Format)
+	}
+}
+
+// envValue renders data as an environment file by default.
+func envValue(data map[string]interface{}) (*secretValue, error) {
+	env, err := renderEnv(data)
+	if err != nil {
+		return nil, err
+	}
+	return &secretValue{Data: data, Default: env}, nil
+}
+
+// renderTemplate executes the text/template text against data.
+func renderTemplate(text string, data map[string]interface{}) ([]byte, error) {
+	tmpl, err := template.New("secret").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing template")
+	}
+
+	var b bytes.Buffer
+	if err = tmpl.Execute(&b, data); err != nil {
+		return nil, errors.Wrap(err, "executing template")
+	}
+	return b.Bytes(), nil
+}
+
+// renderValue renders a single field: strings verbatim, anything else as JSON.
+func renderValue(value interface{}) ([]byte, error) {
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(value)
+}
+
+// renderEnv renders fields as an environment file, as read by systemd's
+// EnvironmentFile= and most shells.
+func renderEnv(data map[string]interface{}) ([]byte, error) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		value, err := renderValue(data[k])
+		if err != nil {
+			return nil, errors.Wrapf(err, "rendering field %s", k)
+		}
+		b.WriteString(envName(k) + "=" + strconv.Quote(string(value)) + "\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// envName turns a field name into a conventional environment variable name.
+func envName(field string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, field)
+}
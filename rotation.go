@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	sddbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/pkg/errors"
+)
+
+// rotationTimeout bounds how long acting on a rotation may take, such as
+// restarting a unit or running a hook.
+const rotationTimeout = 5 * time.Minute
+
+// onRotation acts on a new version of secret being in place: notifying
+// webhooks, restarting or reloading the units configured to pick it up, and
+// running its on_rotate hook. version is the new version, if known.
+func (app *App) onRotation(secret Secret, version string) {
+	app.notifyWebhooks(webhookEvent{
+		Event:   "rotated",
+		Secret:  secret.credentialName(),
+		Path:    app.readPath(secret),
+		Version: version,
+	})
+
+	if len(secret.RestartUnits) > 0 || len(secret.ReloadUnits) > 0 {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), rotationTimeout)
+			defer cancel()
+			if err := restartUnits(ctx, secret.RestartUnits, secret.ReloadUnits); err != nil {
+				logError("Error restarting units for secret %s: %+v", app.readPath(secret), err)
+			}
+		}()
+	}
+
+	if secret.OnRotate != "" {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), rotationTimeout)
+			defer cancel()
+			if err := app.runRotateHook(ctx, secret, version); err != nil {
+				logError("Error running on_rotate hook for secret %s: %+v", app.readPath(secret), err)
+			}
+		}()
+	}
+}
+
+// unwatchedRotations returns the secrets served on sockets which set
+// restart_units, reload_units or on_rotate while watch_events is off. Those
+// settings only take effect on rotations learnt of from Vault's events, or on
+// rewriting a secret in a file, as secrets served on sockets are fetched
+// afresh for each connection with nothing to compare them to.
+func (config *Config) unwatchedRotations() []string {
+	if config.WatchEvents {
+		return nil
+	}
+	actsOnRotation := func(secret Secret) bool {
+		return len(secret.RestartUnits) > 0 || len(secret.ReloadUnits) > 0 || secret.OnRotate != ""
+	}
+
+	var unwatched []string
+	for _, secret := range config.Secrets {
+		if actsOnRotation(secret) {
+			unwatched = append(unwatched, secret.VaultPath)
+		}
+	}
+	for _, d := range config.Discover {
+		if actsOnRotation(d.Secret) {
+			unwatched = append(unwatched, d.Prefix)
+		}
+	}
+	if cs := config.CredentialSocket; cs != nil {
+		for name, secret := range cs.Credentials {
+			if actsOnRotation(secret) {
+				unwatched = append(unwatched, name)
+			}
+		}
+		for _, rule := range cs.Rules {
+			if actsOnRotation(rule.Secret) {
+				unwatched = append(unwatched, rule.Credential+rule.Regex)
+			}
+		}
+	}
+	sort.Strings(unwatched)
+	return unwatched
+}
+
+// warnUnwatchedRotations logs the secrets in config whose rotations won't be
+// acted on.
+func warnUnwatchedRotations(config *Config) {
+	if unwatched := config.unwatchedRotations(); len(unwatched) > 0 {
+		logWarning("Rotations of %s won't be acted on without watch_events", strings.Join(unwatched, ", "))
+	}
+}
+
+// runRotateHook runs secret's on_rotate command with the shell, with the
+// secret's name, Vault path and new version in its environment.
+func (app *App) runRotateHook(ctx context.Context, secret Secret, version string) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", secret.OnRotate)
+	cmd.Env = append(os.Environ(),
+		"SECRET_NAME="+secret.credentialName(),
+		"SECRET_PATH="+app.readPath(secret),
+		"SECRET_VERSION="+version,
+	)
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		log.Printf("on_rotate hook for secret %s: %s", app.readPath(secret), strings.TrimSpace(string(output)))
+	}
+	return err
+}
+
+// restartUnits asks systemd to restart, and reload, the given units, where
+// they are running, and waits for the jobs to finish.
+func restartUnits(ctx context.Context, restart, reload []string) error {
+	connect := sddbus.NewWithContext
+	if userMode() {
+		connect = sddbus.NewUserConnectionContext
+	}
+	conn, err := connect(ctx)
+	if err != nil {
+		return errors.Wrap(err, "connecting to systemd")
+	}
+	defer conn.Close()
+
+	type job struct {
+		unit   string
+		action string
+		done   chan string
+	}
+	var jobs []job
+	for _, unit := range restart {
+		j := job{unit: unit, action: "restart", done: make(chan string, 1)}
+		if _, err = conn.TryRestartUnitContext(ctx, unit, "replace", j.done); err != nil {
+			return errors.Wrapf(err, "restarting %s", unit)
+		}
+		jobs = append(jobs, j)
+	}
+	for _, unit := range reload {
+		j := job{unit: unit, action: "reload", done: make(chan string, 1)}
+		if _, err = conn.ReloadOrTryRestartUnitContext(ctx, unit, "replace", j.done); err != nil {
+			return errors.Wrapf(err, "reloading %s", unit)
+		}
+		jobs = append(jobs, j)
+	}
+
+	for _, j := range jobs {
+		select {
+		case result := <-j.done:
+			if result != "done" {
+				return errors.Errorf("%s of %s finished with %s", j.action, j.unit, result)
+			}
+			log.Printf("Finished %s of %s after secret rotation", j.action, j.unit)
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "waiting for %s of %s", j.action, j.unit)
+		}
+	}
+	return nil
+}
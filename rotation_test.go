@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnwatchedRotations(t *testing.T) {
+	config := &Config{
+		Secrets: []Secret{
+			{SocketPath: "a.sock", VaultPath: "app/a", RestartUnits: []string{"app.service"}},
+			{SocketPath: "b.sock", VaultPath: "app/b"},
+		},
+		Files:    []Secret{{FilePath: "/run/app/c", VaultPath: "app/c", OnRotate: "true"}},
+		Discover: []Discovery{{Prefix: "apps/", Secret: Secret{ReloadUnits: []string{"app.service"}}}},
+		CredentialSocket: &CredentialSocket{
+			Credentials: map[string]Secret{"db": {VaultPath: "app/db", OnRotate: "true"}},
+			Rules:       []CredentialRule{{Credential: "db_{name}", Secret: Secret{RestartUnits: []string{"db.service"}}}},
+		},
+	}
+
+	want := []string{"app/a", "apps/", "db", "db_{name}"}
+	if got := config.unwatchedRotations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unwatchedRotations() = %q, want %q", got, want)
+	}
+
+	config.WatchEvents = true
+	if got := config.unwatchedRotations(); got != nil {
+		t.Errorf("unwatchedRotations() with watch_events = %q, want none", got)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	yamlnode "gopkg.in/yaml.v3"
+)
+
+// durationPattern matches durations as time.ParseDuration reads them.
+const durationPattern = `^[-+]?([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$|^0$`
+
+// schemaEnums are the values allowed for settings taking one of a few, by
+// type and setting.
+var schemaEnums = map[string][]string{
+	"Config.preflight":           {"warn", "fail", "off"},
+	"Config.connection_overflow": {"queue", "refuse"},
+	"Config.log_target":          {"auto", "journal", "stderr"},
+	"Config.dbus":                {"system", "session"},
+	"Config.consistency":         {"read_your_writes", "forward_inconsistent", "forward_always"},
+	"Cluster.consistency":        {"read_your_writes", "forward_inconsistent", "forward_always"},
+	"Secret.type": {"kv", "logical", "cubbyhole", "pki", "database", "ssh", "totp", "aws", "gcp", "azure",
+		"consul", "nomad", "rabbitmq", "ldap", "transit", "datakey", "oidc"},
+	"Secret.format":         {"json", "env", "credentials"},
+	"Secret.metadata":       {"json", "socket"},
+	"Secret.encrypt":        {"host", "tpm2", "host+tpm2", "auto"},
+	"AuthConfig.token_type": {"batch", "service"},
+}
+
+// configSchema returns a JSON Schema describing the configuration file, for
+// editors and CI pipelines to check configurations with.
+func configSchema() map[string]interface{} {
+	defs := map[string]interface{}{}
+	schema := typeSchema(reflect.TypeOf(Config{}), defs)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "systemd-credentials-vault configuration"
+	schema["$defs"] = defs
+
+	var methods []string
+	for method := range authMethods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	auth := defs["AuthConfig"].(map[string]interface{})
+	auth["properties"].(map[string]interface{})["method"].(map[string]interface{})["enum"] = methods
+	return schema
+}
+
+// typeSchema returns the schema of settings of type t, adding those of the
+// structs it is made of to defs.
+func typeSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return map[string]interface{}{"type": "string", "pattern": durationPattern}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t != reflect.TypeOf(Config{}) {
+			if _, ok := defs[t.Name()]; !ok {
+				defs[t.Name()] = nil // Stops recursing through self-referencing types
+				defs[t.Name()] = structSchema(t, defs)
+			}
+			return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+		}
+		return structSchema(t, defs)
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), defs)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema returns the schema of a struct of settings of type t.
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.PkgPath != "" || name == "" || name == "-" {
+			continue
+		}
+		property := typeSchema(field.Type, defs)
+		if enum, ok := schemaEnums[t.Name()+"."+name]; ok {
+			property["enum"] = enum
+		}
+		properties[name] = property
+	}
+	return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// runSchema prints the configuration's JSON Schema.
+func runSchema(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(configSchema())
+}
+
+// schemaErrors checks the YAML document content against the configuration's
+// schema, returning where it doesn't match, by line if located.
+func schemaErrors(name string, content []byte, located bool) []string {
+	var doc yamlnode.Node
+	if yamlnode.Unmarshal(content, &doc) != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	schema := configSchema()
+	check := schemaCheck{defs: schema["$defs"].(map[string]interface{}), name: name, located: located}
+	check.node(doc.Content[0], schema, "")
+	return check.problems
+}
+
+// schemaCheck checks a YAML document against a schema made by typeSchema.
+type schemaCheck struct {
+	defs     map[string]interface{}
+	name     string
+	located  bool
+	problems []string
+}
+
+// problem records what's wrong with node, at path in the document.
+func (c *schemaCheck) problem(node *yamlnode.Node, path, format string, args ...interface{}) {
+	at := c.name
+	if c.located {
+		at = fmt.Sprintf("%s line %d", c.name, node.Line)
+	}
+	if path != "" {
+		at += " " + path
+	}
+	c.problems = append(c.problems, at+": "+fmt.Sprintf(format, args...))
+}
+
+// node checks node, at path in the document, against schema.
+func (c *schemaCheck) node(node *yamlnode.Node, schema map[string]interface{}, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		schema, _ = c.defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+	}
+	if node.Kind == yamlnode.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+
+	switch schema["type"] {
+	case "object":
+		if node.Kind != yamlnode.MappingNode {
+			c.problem(node, path, "expected a mapping")
+			return
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				c.node(value, property, joinPath(path, key))
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				c.node(value, additional, joinPath(path, key))
+			} else if properties != nil {
+				c.problem(node.Content[i], path, "unknown setting %s", key)
+			}
+		}
+
+	case "array":
+		if node.Kind != yamlnode.SequenceNode {
+			c.problem(node, path, "expected a list")
+			return
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range node.Content {
+			c.node(item, items, fmt.Sprintf("%s[%d]", path, i))
+		}
+
+	case "string":
+		// Any scalar reads as a string, e.g. an unquoted mode of 0660
+		if node.Kind != yamlnode.ScalarNode {
+			c.problem(node, path, "expected a value")
+			return
+		}
+		if enum, ok := schema["enum"].([]string); ok && !contains(enum, node.Value) {
+			c.problem(node, path, "%q is not one of %s", node.Value, strings.Join(enum, ", "))
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(node.Value) {
+			c.problem(node, path, "%q is not a duration, e.g. 5m or 1h30m", node.Value)
+		}
+
+	case "integer", "number":
+		if node.Kind != yamlnode.ScalarNode || (node.Tag != "!!int" && node.Tag != "!!float") {
+			c.problem(node, path, "expected a number")
+		} else if schema["type"] == "integer" && node.Tag != "!!int" {
+			c.problem(node, path, "expected a whole number")
+		} else if _, ok := schema["minimum"]; ok && strings.HasPrefix(node.Value, "-") {
+			c.problem(node, path, "expected a number that isn't negative")
+		}
+
+	case "boolean":
+		// YAML 1.1 booleans such as yes and no are read as well
+		if node.Kind != yamlnode.ScalarNode || (node.Tag != "!!bool" && !contains(yaml11Bools, strings.ToLower(node.Value))) {
+			c.problem(node, path, "expected true or false")
+		}
+	}
+}
+
+// yaml11Bools are the booleans of YAML 1.1 besides true and false.
+var yaml11Bools = []string{"yes", "no", "on", "off", "y", "n"}
+
+// joinPath returns the path of key within path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// contains reports whether values includes value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		located bool
+		want    []string
+	}{
+		{
+			name:    "valid",
+			content: "vault_mount: kv\nkv_version: 2\nconnection_timeout: 1m30s\nopenbao: yes\nsecrets:\n  - socket_path: db.sock\n    mode: 0660\n",
+			located: true,
+		},
+		{
+			name:    "unknown setting",
+			content: "vault_mount: kv\nvault_mounts: kv\n",
+			located: true,
+			want:    []string{"config.yml line 2: unknown setting vault_mounts"},
+		},
+		{
+			name:    "unknown setting of a secret",
+			content: "secrets:\n  - socket_path: db.sock\n    vault_paht: app/db\n",
+			located: true,
+			want:    []string{"config.yml line 3 secrets[0]: unknown setting vault_paht"},
+		},
+		{
+			name:    "not one of",
+			content: "preflight: maybe\n",
+			located: true,
+			want:    []string{`config.yml line 1 preflight: "maybe" is not one of warn, fail, off`},
+		},
+		{
+			name:    "formats",
+			content: "secrets:\n  - socket_path: a.sock\n    format: json\n  - socket_path: b.sock\n    type: aws\n    format: credentials\n  - socket_path: c.sock\n    format: yaml\n",
+			located: true,
+			want:    []string{`config.yml line 8 secrets[2].format: "yaml" is not one of json, env, credentials`},
+		},
+		{
+			name:    "duration",
+			content: "connection_timeout: 90\n",
+			located: true,
+			want:    []string{`config.yml line 1 connection_timeout: "90" is not a duration, e.g. 5m or 1h30m`},
+		},
+		{
+			name:    "numbers",
+			content: "kv_version: two\nmax_connections: 1.5\nsecrets:\n  - socket_path: db.sock\n    allowed_uids: [-1]\n",
+			located: true,
+			want: []string{
+				"config.yml line 1 kv_version: expected a number",
+				"config.yml line 2 max_connections: expected a whole number",
+				"config.yml line 5 secrets[0].allowed_uids[0]: expected a number that isn't negative",
+			},
+		},
+		{
+			name:    "booleans",
+			content: "openbao: maybe\n",
+			located: true,
+			want:    []string{"config.yml line 1 openbao: expected true or false"},
+		},
+		{
+			name:    "shapes",
+			content: "secrets: db.sock\nauth: [approle]\nvault_mount: [kv]\n",
+			located: true,
+			want: []string{
+				"config.yml line 1 secrets: expected a list",
+				"config.yml line 2 auth: expected a mapping",
+				"config.yml line 3 vault_mount: expected a value",
+			},
+		},
+		{
+			name:    "named entries",
+			content: "clusters:\n  dr:\n    adress: https://dr\n",
+			located: true,
+			want:    []string{"config.yml line 3 clusters.dr: unknown setting adress"},
+		},
+		{
+			name:    "not located",
+			content: "vault_mounts: kv\n",
+			want:    []string{"config.toml: unknown setting vault_mounts"},
+		},
+		{
+			name:    "null",
+			content: "auth:\nsecrets: ~\n",
+			located: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "config.yml"
+			if !tt.located {
+				name = "config.toml"
+			}
+			if got := schemaErrors(name, []byte(tt.content), tt.located); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("schemaErrors() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSchema(t *testing.T) {
+	var out bytes.Buffer
+	if err := runSchema(&out); err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &schema); err != nil {
+		t.Fatalf("schema is not JSON: %v", err)
+	}
+	if _, ok := schema["$defs"].(map[string]interface{})["Secret"]; !ok {
+		t.Error("schema has no definition of Secret")
+	}
+}
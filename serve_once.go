@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+)
+
+// serveOnce wraps serve so that secret is only served once. After the first
+// successful read the socket is closed, which removes it, and with
+// revoke_after_serve the lease of the credentials served is revoked, so that
+// bootstrap credentials don't remain fetchable. Connections are served one at
+// a time to ensure this. The returned context is cancelled once served.
+func (app *App) serveOnce(ctx context.Context, secret Secret, serve func(net.Conn) bool) (context.Context, *connLimits, func(net.Conn)) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	var mu sync.Mutex
+	served := false
+	return ctx, app.newConnLimits(1), func(c net.Conn) {
+		mu.Lock()
+		defer mu.Unlock()
+		if served {
+			c.Close()
+			return
+		}
+		if !serve(c) {
+			return
+		}
+
+		served = true
+		cancel()
+		// No longer served, so no longer expected by health checks
+		app.served.consume(secret)
+		log.Printf("Served %s once, no longer listening", app.socketAddress(secret))
+		if app.config.FDStore {
+			unstoreSocket(secret)
+		}
+		if secret.RevokeAfterServe {
+			app.leases.revoke(context.Background(), secret.SocketPath)
+		}
+	}
+}
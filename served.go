@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+)
+
+// servedSockets tracks the configured secrets being served, so that they can
+// be changed on reload without disturbing the others.
+type servedSockets struct {
+	sync.Mutex
+	secrets  []Secret
+	stop     map[string]context.CancelFunc
+	consumed map[string]bool // serve_once sockets already served, which reloads mustn't serve again
+}
+
+// serveSecret listens on secret's socket and serves it until ctx is cancelled
+// or it is stopped.
+func (app *App) serveSecret(ctx context.Context, secret Secret) error {
+	ln, err := app.listenSocket(secret)
+	if err != nil {
+		return err
+	}
+	serveCtx, cancel := context.WithCancel(ctx)
+	closed := make(chan struct{})
+	// Stopping waits for the listener to be closed, so that the socket can be
+	// bound again straight away
+	stop := func() {
+		cancel()
+		<-closed
+	}
+
+	app.served.Lock()
+	if app.served.stop == nil {
+		app.served.stop = map[string]context.CancelFunc{}
+	}
+	app.served.secrets = append(app.served.secrets, secret)
+	app.served.stop[secret.SocketPath] = stop
+	app.served.Unlock()
+
+	go func() {
+		defer close(closed)
+		app.serveHealing(serveCtx, secret, ln, func(ctx context.Context, ln net.Listener) {
+			app.socketSecretListen(ctx, secret, ln)
+		})
+	}()
+	return nil
+}
+
+// stopSecret stops serving secret and removes its socket, revoking the lease
+// of its credentials if leases are revoked on shutdown.
+func (app *App) stopSecret(secret Secret) {
+	stop, ok := app.served.remove(secret)
+	if !ok {
+		return
+	}
+
+	stop()
+	app.removeSocket(secret)
+	if app.config.FDStore {
+		unstoreSocket(secret)
+	}
+	if app.config.RevokeLeasesOnShutdown {
+		app.leases.revoke(context.Background(), secret.SocketPath)
+	}
+	log.Printf("Stopped serving %s", app.socketAddress(secret))
+}
+
+// remove forgets secret, returning the function that stops serving it, once
+// its listener is closed, if it was being served.
+func (s *servedSockets) remove(secret Secret) (context.CancelFunc, bool) {
+	s.Lock()
+	defer s.Unlock()
+	stop, ok := s.stop[secret.SocketPath]
+	delete(s.stop, secret.SocketPath)
+	for i, served := range s.secrets {
+		if served.SocketPath == secret.SocketPath {
+			s.secrets = append(s.secrets[:i:i], s.secrets[i+1:]...)
+			break
+		}
+	}
+	return stop, ok
+}
+
+// consume forgets secret once it has been served by serve_once, remembering
+// not to serve it again.
+func (s *servedSockets) consume(secret Secret) {
+	s.remove(secret)
+	s.Lock()
+	defer s.Unlock()
+	if s.consumed == nil {
+		s.consumed = map[string]bool{}
+	}
+	s.consumed[secret.SocketPath] = true
+}
+
+// wasConsumed reports whether secret has been served by serve_once.
+func (s *servedSockets) wasConsumed(secret Secret) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.consumed[secret.SocketPath]
+}
+
+// servedSecrets returns the configured secrets being served.
+func (app *App) servedSecrets() []Secret {
+	app.served.Lock()
+	defer app.served.Unlock()
+	return append([]Secret(nil), app.served.secrets...)
+}
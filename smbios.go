@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// runSMBIOS implements the smbios subcommand, which fetches the configured
+// secrets and prints the qemu arguments passing them to a guest as systemd
+// credentials, picked up by systemd inside the guest at boot. By default they
+// are SMBIOS type 11 OEM strings; with -fw-cfg-dir they are written to files
+// there and passed with fw_cfg, which suits larger credentials.
+func runSMBIOS(config *Config, args []string) error {
+	flags := flag.NewFlagSet("smbios", flag.ExitOnError)
+	fwCfgDir := flags.String("fw-cfg-dir", "", "Write credentials to files in this directory, passed to qemu with fw_cfg.")
+	flags.Parse(args)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app, credentials, err := fetchCredentials(ctx, config)
+	if app != nil {
+		defer app.revokeTokens(context.Background())
+	}
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(credentials))
+	for name := range credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if *fwCfgDir == "" {
+			value := base64.StdEncoding.EncodeToString(credentials[name])
+			fmt.Printf("-smbios type=11,value=io.systemd.credential.binary:%s=%s\n", qemuEscape(name), value)
+			continue
+		}
+
+		path := filepath.Join(*fwCfgDir, name)
+		if err = os.MkdirAll(*fwCfgDir, 0700); err != nil {
+			return errors.Wrap(err, "creating fw_cfg directory")
+		}
+		if err = ioutil.WriteFile(path, credentials[name], 0600); err != nil {
+			return errors.Wrapf(err, "writing credential %s", name)
+		}
+		fmt.Printf("-fw_cfg name=opt/io.systemd.credentials/%s,file=%s\n", qemuEscape(name), qemuEscape(path))
+	}
+	return nil
+}
+
+// qemuEscape escapes commas in a qemu option value by doubling them.
+func qemuEscape(s string) string {
+	return strings.ReplaceAll(s, ",", ",,")
+}
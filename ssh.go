@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type SSHSecret struct {
+	Role            string   `yaml:"role"`             // The SSH role to sign with
+	PublicKeyPath   string   `yaml:"public_key_path"`  // The public key to sign, e.g. /etc/ssh/ssh_host_ed25519_key.pub
+	CertType        string   `yaml:"cert_type"`        // host or user (default: user)
+	ValidPrincipals []string `yaml:"valid_principals"` // The principals (hostnames or usernames) to sign for (optional)
+	TTL             string   `yaml:"ttl"`              // The requested certificate lifetime (optional, defaults to the role's TTL)
+}
+
+// signSSHKey has Vault sign the configured public key for an ssh secret,
+// serving the signed certificate by default.
+func (app *App) signSSHKey(ctx context.Context, secret Secret) (*secretValue, error) {
+	if secret.SSH == nil || secret.SSH.Role == "" || secret.SSH.PublicKeyPath == "" {
+		return nil, errors.New("ssh secrets require an ssh role and public_key_path")
+	}
+
+	publicKey, err := ioutil.ReadFile(secret.SSH.PublicKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading public key")
+	}
+
+	data := map[string]interface{}{
+		"public_key": string(publicKey),
+	}
+	if secret.SSH.CertType != "" {
+		data["cert_type"] = secret.SSH.CertType
+	}
+	if len(secret.SSH.ValidPrincipals) > 0 {
+		data["valid_principals"] = strings.Join(secret.SSH.ValidPrincipals, ",")
+	}
+	if secret.SSH.TTL != "" {
+		data["ttl"] = secret.SSH.TTL
+	}
+
+	path := app.readPath(secret)
+	s, err := app.clientFor(secret).Logical().WriteWithContext(ctx, path, requestData(secret, data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "signing public key with %s", path)
+	}
+	if s == nil {
+		return nil, errors.Errorf("no certificate returned from %s", path)
+	}
+
+	signed, _ := s.Data["signed_key"].(string)
+	return &secretValue{Data: s.Data, Default: []byte(signed)}, nil
+}
@@ -0,0 +1,5 @@
+package main
+
+type TOTPSecret struct {
+	Key string `yaml:"key"` // The TOTP key to generate codes for
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type TransitSecret struct {
+	Key             string `yaml:"key"`              // The transit key to decrypt with
+	Ciphertext      string `yaml:"ciphertext"`       // The ciphertext to decrypt, e.g. vault:v1:... (optional, otherwise read from vault_path)
+	KVMount         string `yaml:"kv_mount"`         // The KV mount vault_path is read from (optional, defaults to vault_mount)
+	CiphertextField string `yaml:"ciphertext_field"` // The field of the KV secret holding the ciphertext (default: ciphertext)
+	Context         string `yaml:"context"`          // The base64 encoded key derivation context, for derived keys (optional)
+
+	// Operation turns the socket into a request/response service: clients
+	// write a payload, shut down their side of the connection, and read back
+	// the result of the operation on it. One of encrypt, decrypt, or sign.
+	Operation string `yaml:"operation"`
+
+	Bits int `yaml:"bits"` // The size of data keys generated for datakey secrets: 128, 256 or 512 (optional, defaults to 256)
+}
+
+// maxTransitPayload bounds the payload accepted from a client in request mode.
+const maxTransitPayload = 1 << 20
+
+// ciphertextSource returns the KV secret a transit secret's ciphertext is read from.
+func (s Secret) ciphertextSource() Secret {
+	source := s
+	source.Type = "kv"
+	source.Mount = s.Transit.KVMount
+	return source
+}
+
+// decryptSecret decrypts the ciphertext configured for a transit secret, or
+// stored in KV at its vault_path, so the plaintext never rests in Vault.
+func (app *App) decryptSecret(ctx context.Context, secret Secret) (*secretValue, error) {
+	ciphertext := secret.Transit.Ciphertext
+	if ciphertext == "" {
+		if secret.VaultPath == "" {
+			return nil, errors.New("transit secrets require a ciphertext or vault_path")
+		}
+		kv, err := app.fetchKV(ctx, secret.ciphertextSource())
+		if err != nil {
+			return nil, errors.Wrap(err, "reading ciphertext")
+		}
+
+		field := secret.Transit.CiphertextField
+		if field == "" {
+			field = "ciphertext"
+		}
+		ciphertext, _ = kv.Data[field].(string)
+		if ciphertext == "" {
+			return nil, errors.Errorf("secret %s has no ciphertext in field %q", secret.VaultPath, field)
+		}
+	}
+
+	data := map[string]interface{}{"ciphertext": ciphertext}
+	if secret.Transit.Context != "" {
+		data["context"] = secret.Transit.Context
+	}
+
+	s, err := app.transit(ctx, secret, "decrypt", data)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, _ := s.Data["plaintext"].(string)
+	plaintext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding plaintext")
+	}
+
+	return &secretValue{
+		Data:    map[string]interface{}{"plaintext": string(plaintext)},
+		Default: plaintext,
+	}, nil
+}
+
+// generateDataKey generates a new data key for a datakey secret, served as
+// JSON holding both the plaintext key and its wrapped form for storing
+// alongside the data it encrypts.
+func (app *App) generateDataKey(ctx context.Context, secret Secret) (*secretValue, error) {
+	data := map[string]interface{}{}
+	if secret.Transit.Context != "" {
+		data["context"] = secret.Transit.Context
+	}
+	if secret.Transit.Bits != 0 {
+		data["bits"] = secret.Transit.Bits
+	}
+
+	s, err := app.transit(ctx, secret, "datakey/plaintext", data)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := json.Marshal(s.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &secretValue{Data: s.Data, Default: content}, nil
+}
+
+// serveTransitRequest reads a payload from c and writes back the result of the
+// transit secret's operation on it.
+func (app *App) serveTransitRequest(ctx context.Context, secret Secret, c net.Conn) {
+	defer c.Close()
+
+	payload, err := ioutil.ReadAll(io.LimitReader(c, maxTransitPayload+1))
+	if err != nil {
+		logError("Error reading transit request on %s: %+v", secret.SocketPath, err)
+		return
+	}
+	if len(payload) > maxTransitPayload {
+		logWarning("Transit request on %s exceeds %d bytes", secret.SocketPath, maxTransitPayload)
+		return
+	}
+
+	result, err := app.transitOperation(ctx, secret, payload)
+	if err != nil {
+		logError("%+v", err)
+		if isPermissionDenied(err) {
+			app.requestReauth(secret)
+		}
+		return
+	}
+	if _, err = c.Write(result); err != nil {
+		logError("%v", err)
+	}
+}
+
+// transitOperation performs a transit secret's operation on payload.
+func (app *App) transitOperation(ctx context.Context, secret Secret, payload []byte) ([]byte, error) {
+	data := map[string]interface{}{}
+	if secret.Transit.Context != "" {
+		data["context"] = secret.Transit.Context
+	}
+
+	switch secret.Transit.Operation {
+	case "encrypt":
+		data["plaintext"] = base64.StdEncoding.EncodeToString(payload)
+		s, err := app.transit(ctx, secret, "encrypt", data)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, _ := s.Data["ciphertext"].(string)
+		return []byte(ciphertext), nil
+
+	case "decrypt":
+		data["ciphertext"] = strings.TrimSpace(string(payload))
+		s, err := app.transit(ctx, secret, "decrypt", data)
+		if err != nil {
+			return nil, err
+		}
+		encoded, _ := s.Data["plaintext"].(string)
+		plaintext, err := base64.StdEncoding.DecodeString(encoded)
+		return plaintext, errors.Wrap(err, "decoding plaintext")
+
+	case "sign":
+		data["input"] = base64.StdEncoding.EncodeToString(payload)
+		s, err := app.transit(ctx, secret, "sign", data)
+		if err != nil {
+			return nil, err
+		}
+		signature, _ := s.Data["signature"].(string)
+		return []byte(signature), nil
+
+	default:
+		return nil, errors.Errorf("unsupported transit operation %q", secret.Transit.Operation)
+	}
+}
+
+// transit performs operation with a transit secret's key.
+func (app *App) transit(ctx context.Context, secret Secret, operation string, data map[string]interface{}) (*api.Secret, error) {
+	path := app.transitPath(secret, operation)
+	s, err := app.clientFor(secret).Logical().WriteWithContext(ctx, path, requestData(secret, data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s with %s", operation, path)
+	}
+	if s == nil {
+		return nil, errors.Errorf("no result returned from %s", path)
+	}
+	return s, nil
+}
+
+// transitPath returns the path of an operation on a transit secret's key.
+func (app *App) transitPath(secret Secret, operation string) string {
+	return fmt.Sprintf("%s/%s/%s", app.mountOr(secret, "transit"), operation, secret.role())
+}
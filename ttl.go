@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	defaultTokenTTLWarning = 10 * time.Minute
+	tokenTTLCheckInterval  = time.Minute
+)
+
+// monitorTokenTTL periodically looks up the Vault token, recording its
+// remaining TTL and logging increasingly urgent warnings once it falls below
+// threshold. Renewal normally keeps the TTL well above that, so the warnings
+// point at renewal or re-authentication having broken.
+func monitorTokenTTL(ctx context.Context, client *api.Client, threshold time.Duration) {
+	if threshold <= 0 {
+		threshold = defaultTokenTTLWarning
+	}
+
+	ticker := time.NewTicker(tokenTTLCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		self, err := client.Auth().Token().LookupSelfWithContext(ctx)
+		if err != nil {
+			logError("Error looking up Vault token TTL: %+v", err)
+		} else if ttl, err := self.TokenTTL(); err != nil {
+			logError("Error reading Vault token TTL: %+v", err)
+		} else {
+			metricTokenTTL.Set(int64(ttl.Seconds()))
+
+			switch {
+			case ttl == 0:
+				// The token never expires
+			case ttl < threshold/4:
+				logError("CRITICAL: Vault token expires in %s", ttl)
+			case ttl < threshold/2:
+				logWarning("WARNING: Vault token expires in %s", ttl)
+			case ttl < threshold:
+				log.Printf("Vault token expires in %s", ttl)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
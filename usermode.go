@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// userMode reports whether the daemon is running for a user, as a service of
+// systemd --user, rather than for the system. Paths then default to the
+// user's runtime directory, and the user's service manager is dealt with.
+func userMode() bool {
+	return os.Geteuid() != 0 && os.Getenv("XDG_RUNTIME_DIR") != ""
+}
+
+// runtimeDir returns the directory runtime files are kept in by default.
+func runtimeDir() string {
+	if userMode() {
+		return os.Getenv("XDG_RUNTIME_DIR")
+	}
+	return "/run"
+}
+
+// defaultSocketRoot returns the socket_root used when none is configured.
+func defaultSocketRoot() string {
+	return filepath.Join(runtimeDir(), "vault-credentials") + "/"
+}
+
+// managerPID returns the PID of the service manager loading credentials:
+// systemd itself, or the user's systemd --user in user mode, which it passes
+// to its services as $MANAGERPID.
+func managerPID() int32 {
+	if userMode() {
+		if pid, err := strconv.Atoi(os.Getenv("MANAGERPID")); err == nil {
+			return int32(pid)
+		}
+	}
+	return 1
+}
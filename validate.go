@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	yamlnode "gopkg.in/yaml.v3"
+)
+
+// configErrors lists every problem found with a configuration, so that they
+// can all be fixed at once rather than one restart at a time.
+type configErrors []string
+
+func (e configErrors) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e, "\n  ")
+}
+
+// configChecks carries what has been seen of a configuration across the
+// files it is read from, so that conflicts between them are found too.
+type configChecks struct {
+	addresses map[string]string // Where each socket address was configured
+	files     map[string]string // Where each file under files was configured
+}
+
+func newConfigChecks() *configChecks {
+	return &configChecks{addresses: map[string]string{}, files: map[string]string{}}
+}
+
+// validate checks the configuration parsed from the YAML content, read from
+// the file name, for mistakes which would otherwise only surface once secrets
+// are served, along with parseErr, any error parsing it. Problems are located
+// by their lines in content if it is located, as it isn't when converted from
+// another format, or otherwise by their place in the configuration.
+func (config *Config) validate(name string, content []byte, located bool, parseErr error, checks *configChecks) error {
+	typeErr, ok := parseErr.(*yaml.TypeError)
+	if parseErr != nil && !ok {
+		return parseErr
+	}
+
+	// The schema finds unknown keys and mistyped values, by their place
+	// as well as their line, as well as values that aren't allowed
+	problems := configErrors(schemaErrors(name, content, located))
+	if len(problems) == 0 && typeErr != nil {
+		for _, problem := range typeErr.Errors {
+			if !located {
+				problem = linePrefix.ReplaceAllString(problem, "")
+			}
+			problems = append(problems, name+" "+problem)
+		}
+	}
+	if !located {
+		content = nil
+	}
+
+	problems = append(problems, defaultsErrors(name, config.Defaults)...)
+	if cs := config.CredentialSocket; cs != nil && cs.SocketPath != "" {
+		if first, ok := checks.addresses[cs.SocketPath]; ok {
+			problems = append(problems, fmt.Sprintf("%s credential_socket: %s is already served by %s", name, cs.SocketPath, first))
+		} else {
+			checks.addresses[cs.SocketPath] = name + " credential_socket"
+		}
+	}
+	problems = append(problems, validateSecrets(config.Secrets, entryLocator(name, entryLines(content, "secrets"), "secrets"), checks.addresses)...)
+	problems = append(problems, validateFiles(config.Files, entryLocator(name, entryLines(content, "files"), "files"), checks.files)...)
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// linePrefix matches the line YAML errors are prefixed with.
+var linePrefix = regexp.MustCompile(`^line \d+: `)
+
+// validateSecrets checks that each secret has somewhere to be served and read
+// from, and that no two are served at the same address, as recorded in
+// addresses. at describes where each secret was configured.
+func validateSecrets(secrets []Secret, at func(int) string, addresses map[string]string) []string {
+	var problems []string
+	for i, secret := range secrets {
+		at := at(i)
+		if secret.SocketPath == "" && secret.VSockPort == 0 {
+			problems = append(problems, at+": socket_path is required")
+		}
+		if secret.needsVaultPath() && secret.VaultPath == "" {
+			problems = append(problems, at+": vault_path is required")
+		}
+		problems = append(problems, vaultErrors(at, secret)...)
+
+		served := append([]string{secret.SocketPath}, secret.Aliases...)
+		if secret.Metadata == "socket" {
+			served = append(served, secret.SocketPath+".meta")
+		}
+		if secret.VSockPort != 0 {
+			served[0] = fmt.Sprintf("vsock port %d", secret.VSockPort)
+		}
+		for _, address := range served {
+			if address == "" {
+				continue
+			}
+			if first, ok := addresses[address]; ok {
+				problems = append(problems, fmt.Sprintf("%s: %s is already served by %s", at, address, first))
+				continue
+			}
+			addresses[address] = at
+		}
+	}
+	return problems
+}
+
+// validateFiles checks that each secret under files has a file of its own to
+// be written to, as recorded in paths.
+func validateFiles(files []Secret, at func(int) string, paths map[string]string) []string {
+	var problems []string
+	for i, file := range files {
+		at := at(i)
+		if file.FilePath == "" {
+			problems = append(problems, at+": file_path is required")
+		} else if first, ok := paths[file.FilePath]; ok {
+			problems = append(problems, fmt.Sprintf("%s: %s is already written by %s", at, file.FilePath, first))
+		} else {
+			paths[file.FilePath] = at
+		}
+		if file.needsVaultPath() && file.VaultPath == "" {
+			problems = append(problems, at+": vault_path is required")
+		}
+		problems = append(problems, vaultErrors(at, file)...)
+	}
+	return problems
+}
+
+// vaultErrors returns the problems with the Vault server set on secret.
+func vaultErrors(at string, secret Secret) []string {
+	if secret.Vault == nil {
+		return nil
+	}
+
+	var problems []string
+	if secret.Vault.Address == "" {
+		problems = append(problems, at+": vault needs an address")
+	}
+	if secret.Cluster != "" || secret.Identity != "" {
+		problems = append(problems, at+": vault can't be set along with cluster or identity")
+	}
+	return problems
+}
+
+// needsVaultPath reports whether the secret is read from a path of its own,
+// rather than being issued by a role.
+func (s Secret) needsVaultPath() bool {
+	switch s.Type {
+	case "", "kv", "logical", "cubbyhole":
+		return true
+	}
+	return false
+}
+
+// entryLines returns the line of each entry of the list under key in the
+// YAML document content, or of the document itself if key is empty. Lines
+// are only for reporting, so nothing is returned if they can't be found.
+func entryLines(content []byte, key string) []int {
+	var doc yamlnode.Node
+	if yamlnode.Unmarshal(content, &doc) != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	list := doc.Content[0]
+	if key != "" {
+		list = nil
+		root := doc.Content[0]
+		for i := 0; root.Kind == yamlnode.MappingNode && i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value == key {
+				list = root.Content[i+1]
+			}
+		}
+	}
+	if list == nil || list.Kind != yamlnode.SequenceNode {
+		return nil
+	}
+
+	lines := make([]int, len(list.Content))
+	for i, entry := range list.Content {
+		lines[i] = entry.Line
+	}
+	return lines
+}
+
+// entryLocator returns a description of where entry i of section is in the
+// file name, by its line if known.
+func entryLocator(name string, lines []int, section string) func(int) string {
+	return func(i int) string {
+		if i < len(lines) {
+			return fmt.Sprintf("%s line %d", name, lines[i])
+		}
+		return fmt.Sprintf("%s %s[%d]", name, section, i)
+	}
+}
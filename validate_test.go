@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-yaml/yaml"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		located bool
+		want    configErrors
+	}{
+		{
+			name:    "valid",
+			content: "secrets:\n  - socket_path: a.sock\n    vault_path: app/a\n  - socket_path: cert.sock\n    type: pki\n",
+			located: true,
+		},
+		{
+			name:    "required settings",
+			content: "secrets:\n  - vault_path: app/a\n\n  - socket_path: b.sock\n",
+			located: true,
+			want: configErrors{
+				"config.yml line 2: socket_path is required",
+				"config.yml line 4: vault_path is required",
+			},
+		},
+		{
+			name:    "served twice",
+			content: "secrets:\n  - socket_path: a.sock\n    vault_path: app/a\n    aliases: [b.sock]\n  - socket_path: b.sock\n    vault_path: app/b\n  - socket_path: a.sock.meta\n    vault_path: app/c\n  - socket_path: c.sock\n    vault_path: app/c\n    metadata: socket\n    aliases: [a.sock]\n",
+			located: true,
+			want: configErrors{
+				"config.yml line 5: b.sock is already served by config.yml line 2",
+				"config.yml line 9: a.sock is already served by config.yml line 2",
+			},
+		},
+		{
+			name:    "files",
+			content: "files:\n  - vault_path: app/a\n    file_path: /run/app/a\n  - vault_path: app/b\n    file_path: /run/app/a\n  - vault_path: app/c\n",
+			located: true,
+			want: configErrors{
+				"config.yml line 4: /run/app/a is already written by config.yml line 2",
+				"config.yml line 6: file_path is required",
+			},
+		},
+		{
+			name:    "vault",
+			content: "secrets:\n  - socket_path: a.sock\n    vault_path: app/a\n    cluster: dr\n    vault: {mount: kv}\n",
+			located: true,
+			want: configErrors{
+				"config.yml line 2: vault needs an address",
+				"config.yml line 2: vault can't be set along with cluster or identity",
+			},
+		},
+		{
+			name:    "not located",
+			content: "secrets:\n  - socket_path: a.sock\n    vault_path: app/a\n  - vault_path: app/b\n",
+			want:    configErrors{"config.yml secrets[1]: socket_path is required"},
+		},
+		{
+			name:    "unknown setting",
+			content: "secrets:\n  - socket_path: a.sock\n    vault_paht: app/a\n",
+			located: true,
+			want: configErrors{
+				"config.yml line 3 secrets[0]: unknown setting vault_paht",
+				"config.yml line 2: vault_path is required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			content := []byte(tt.content)
+			err := config.validate("config.yml", content, tt.located, yaml.UnmarshalStrict(content, config), newConfigChecks())
+			var got configErrors
+			if err != nil {
+				var ok bool
+				if got, ok = err.(configErrors); !ok {
+					t.Fatalf("validate() error = %v", err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAcrossFiles(t *testing.T) {
+	checks := newConfigChecks()
+	first := &Config{Secrets: []Secret{{SocketPath: "a.sock", VaultPath: "app/a"}}}
+	if err := first.validate("config.yml", nil, false, nil, checks); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+
+	second := &Config{Secrets: []Secret{{SocketPath: "b.sock", VaultPath: "app/b"}, {SocketPath: "a.sock", VaultPath: "app/c"}}}
+	err := second.validate("conf.d/app.yml", nil, false, nil, checks)
+	want := configErrors{"conf.d/app.yml secrets[1]: a.sock is already served by config.yml secrets[0]"}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("validate() = %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"net"
+
+	"github.com/mdlayher/vsock"
+	"github.com/pkg/errors"
+)
+
+// listenVSock listens on secret's AF_VSOCK port, for virtual machine guests
+// on this host. systemd in a guest can import credentials served this way.
+func (app *App) listenVSock(secret Secret) (net.Listener, error) {
+	log.Printf("Listening on vsock port %d for secret path %s", secret.VSockPort, app.readPath(secret))
+	ln, err := vsock.Listen(secret.VSockPort, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listening on vsock port %d", secret.VSockPort)
+	}
+	return ln, nil
+}
+
+// authorizeGuest checks that the guest connected on c, if it is a vsock
+// connection, has one of secret's allowed context IDs.
+func authorizeGuest(secret Secret, c net.Conn) error {
+	if len(secret.AllowedCIDs) == 0 {
+		return nil
+	}
+	addr, ok := c.RemoteAddr().(*vsock.Addr)
+	if !ok {
+		return errors.New("not a vsock connection")
+	}
+	if !containsID(secret.AllowedCIDs, addr.ContextID) {
+		return errors.Errorf("guest with context ID %d is not allowed", addr.ContextID)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// watchFile signals on the returned channel whenever the content of path
+// changes. The containing directory is watched rather than the file itself so
+// that atomic renames and symlink swaps (as used by the kubelet and Vault
+// Agent) are picked up too.
+func watchFile(ctx context.Context, path string) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating file watcher")
+	}
+
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, errors.Wrapf(err, "watching %s", path)
+	}
+
+	changed := make(chan struct{}, 1)
+	last := fileDigest(path)
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-watcher.Errors:
+				logError("Error watching %s: %+v", path, err)
+			case <-watcher.Events:
+				// Events for unrelated files in the directory are cheap to
+				// filter by comparing content.
+				digest := fileDigest(path)
+				if digest == last || digest == ([sha256.Size]byte{}) {
+					continue
+				}
+				last = digest
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changed, nil
+}
+
+// watchDir signals on the returned channel whenever a file in dir is
+// created, changed, or removed.
+func watchDir(ctx context.Context, dir string) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating file watcher")
+	}
+
+	if err = watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, errors.Wrapf(err, "watching %s", dir)
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-watcher.Errors:
+				logError("Error watching %s: %+v", dir, err)
+			case event := <-watcher.Events:
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changed, nil
+}
+
+// fileDigest returns the SHA-256 digest of the content of path, or the zero
+// value if it can't be read.
+func fileDigest(path string) [sha256.Size]byte {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(content)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// runWatchdog pings systemd's watchdog while the daemon is healthy, if
+// WatchdogSec= is set for the service, so that a hung daemon is restarted.
+func (app *App) runWatchdog(ctx context.Context) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		logError("Error reading watchdog settings: %+v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	log.Printf("Pinging the systemd watchdog every %s", interval/2)
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval/2)
+		err := app.checkHealth(checkCtx)
+		cancel()
+		if err != nil {
+			logWarning("Health check failed, not pinging the watchdog: %+v", err)
+		} else if _, err = daemon.SdNotify(false, daemon.SdNotifyWatchdog); err != nil {
+			logError("Error pinging the watchdog: %+v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth checks that the sockets the daemon bound are still in place and
+// that Vault is reachable, initialized and unsealed.
+func (app *App) checkHealth(ctx context.Context) error {
+	for _, secret := range app.servedSecrets() {
+		if _, ok := app.activatedListener(secret); ok || !secret.onFilesystem() {
+			continue
+		}
+		sockPath := app.config.SocketRoot + secret.SocketPath
+		info, err := os.Stat(sockPath)
+		if err != nil {
+			return errors.Wrap(err, "checking socket")
+		}
+		if info.Mode()&os.ModeSocket == 0 {
+			return errors.Errorf("%s is no longer a socket", sockPath)
+		}
+	}
+
+	return serverHealth(ctx, app.client, app.config.defaultCluster())
+}
+
+// serverHealth checks that the server of cluster, reached with client, is
+// initialized and unsealed.
+func serverHealth(ctx context.Context, client *api.Client, cluster *Cluster) error {
+	var initialized, sealed bool
+	if cluster.OpenBao {
+		health, err := readOpenBaoHealth(ctx, client)
+		if err != nil {
+			return errors.Wrap(err, "checking OpenBao health")
+		}
+		initialized, sealed = health.Initialized, health.Sealed
+	} else {
+		health, err := client.Sys().HealthWithContext(ctx)
+		if err != nil {
+			return errors.Wrap(err, "checking Vault health")
+		}
+		initialized, sealed = health.Initialized, health.Sealed
+	}
+
+	if !initialized {
+		return errors.Errorf("%s is not initialized", cluster.product())
+	}
+	if sealed {
+		return errors.Errorf("%s is sealed", cluster.product())
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Webhook is an HTTP endpoint notified of secret rotations and fetch
+// failures, for monitoring and orchestration systems.
+type Webhook struct {
+	URL    string   `yaml:"url"`    // Where notifications are POSTed as JSON
+	Events []string `yaml:"events"` // The events notified: rotated, fetch_failed (default: both)
+
+	SigningKey     string `yaml:"signing_key"`            // A key the body is signed with, as an HMAC-SHA256 in the X-Signature-256 header (optional)
+	SigningKeyFile string `yaml:"signing_key_file"`       // A file containing the signing key
+	SigningKeyEnv  string `yaml:"signing_key_env"`        // An environment variable containing the signing key
+	SigningKeyCred string `yaml:"signing_key_credential"` // A systemd credential (in $CREDENTIALS_DIRECTORY) containing the signing key
+}
+
+// webhookEvent is the body of a webhook notification.
+type webhookEvent struct {
+	Event    string    `json:"event"`
+	Secret   string    `json:"secret"`
+	Path     string    `json:"path"`
+	Version  string    `json:"version,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Failures int       `json:"failures,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// webhookTimeout bounds how long a webhook notification may take.
+const webhookTimeout = 10 * time.Second
+
+// defaultFetchFailureThreshold is how many times in a row a secret must fail
+// to be fetched before webhooks are notified, when not configured.
+const defaultFetchFailureThreshold = 3
+
+// fetchFailures counts the consecutive failures to fetch each secret.
+type fetchFailures struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+// wants reports whether the webhook is notified of event.
+func (w *Webhook) wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyWebhooks sends event to each webhook wanting it, in the background.
+func (app *App) notifyWebhooks(event webhookEvent) {
+	event.Time = time.Now().UTC()
+	for i := range app.config.Webhooks {
+		webhook := &app.config.Webhooks[i]
+		if !webhook.wants(event.Event) {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+			defer cancel()
+			if err := webhook.send(ctx, event); err != nil {
+				logError("Error notifying webhook %s of %s: %+v", webhook.URL, event.Event, err)
+			}
+		}()
+	}
+}
+
+// send POSTs event to the webhook, signed if it has a signing key.
+func (w *Webhook) send(ctx context.Context, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	key, err := readValue("signing_key", w.SigningKey, w.SigningKeyFile, w.SigningKeyEnv, w.SigningKeyCred)
+	if err != nil && !errors.Is(err, errNoValue) {
+		return err
+	}
+	if key != "" {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	_, err = doHTTP(req)
+	return err
+}
+
+// fetchResult records the outcome of fetching secret, notifying webhooks once
+// it has failed fetch_failure_threshold times in a row.
+func (app *App) fetchResult(secret Secret, err error) {
+	if len(app.config.Webhooks) == 0 {
+		return
+	}
+
+	app.failures.Lock()
+	defer app.failures.Unlock()
+	if err == nil {
+		delete(app.failures.counts, secret.SocketPath)
+		return
+	}
+	if app.failures.counts == nil {
+		app.failures.counts = map[string]int{}
+	}
+	app.failures.counts[secret.SocketPath]++
+
+	threshold := app.config.FetchFailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFetchFailureThreshold
+	}
+	if failures := app.failures.counts[secret.SocketPath]; failures == threshold {
+		app.notifyWebhooks(webhookEvent{
+			Event:    "fetch_failed",
+			Secret:   secret.credentialName(),
+			Path:     app.readPath(secret),
+			Error:    err.Error(),
+			Failures: failures,
+		})
+	}
+}
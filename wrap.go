@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// wrapSecret reads secret with response wrapping, returning the wrapping token
+// in place of the secret. The consuming unit unwraps it with sys/wrapping/unwrap,
+// which succeeds only once, so any other reader of the credential is detected.
+func (app *App) wrapSecret(ctx context.Context, secret Secret) (*secretValue, error) {
+	if fetchCapability(secret) != "read" {
+		return nil, errors.Errorf("wrap_ttl is not supported for %s secrets", secret.Type)
+	}
+
+	path := app.readPath(secret)
+	client := app.clientFor(secret)
+	r := client.NewRequest("GET", "/v1/"+path)
+	r.WrapTTL = secret.WrapTTL
+	if (secret.Type == "" || secret.Type == "kv") && secret.Version != 0 {
+		r.Params.Set("version", strconv.Itoa(secret.Version))
+	}
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading wrapped %s", path)
+	}
+
+	s, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing wrapped response from %s", path)
+	}
+	if s == nil || s.WrapInfo == nil {
+		return nil, errors.Errorf("no wrapping token returned from %s", path)
+	}
+
+	return &secretValue{
+		Data: map[string]interface{}{
+			"token":         s.WrapInfo.Token,
+			"accessor":      s.WrapInfo.Accessor,
+			"ttl":           s.WrapInfo.TTL,
+			"creation_time": s.WrapInfo.CreationTime.Format(time.RFC3339),
+			"creation_path": s.WrapInfo.CreationPath,
+		},
+		Default: []byte(s.WrapInfo.Token),
+	}, nil
+}